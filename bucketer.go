@@ -2,9 +2,14 @@ package fetchmgr
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
+	"hash/maphash"
 	"io"
+	"math"
+	"reflect"
 	"unsafe"
 )
 
@@ -19,9 +24,46 @@ func NewBucketedCFetcher(fs []CFetcher) BucketedCFetcher {
 
 // CFetch calls one of internal Fetchers
 func (bf BucketedCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	return pickBucket(bf, hash(key)).CFetch(cancel, key)
+}
+
+// CtxFetch calls one of internal Fetchers with context.Context
+func (bf BucketedCFetcher) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return ctxFetch(pickBucket(bf, hash(key)), ctx, key)
+}
+
+func pickBucket(bf BucketedCFetcher, h uint) CFetcher {
 	fs := ([]CFetcher)(bf)
-	i := hash(key) % uint(len(fs))
-	return fs[i].CFetch(cancel, key)
+	return fs[h%uint(len(fs))]
+}
+
+// hashBucketedCFetcher is BucketedCFetcher with the custom hash function
+type hashBucketedCFetcher struct {
+	BucketedCFetcher
+	hash func(interface{}) uint
+}
+
+// NewBucketedCFetcherWithHash creates BucketedCFetcher which scatters tasks
+// by hashFunc instead of the default hash function. The default one is used
+// if hashFunc is nil.
+func NewBucketedCFetcherWithHash(
+	fs []CFetcher,
+	hashFunc func(interface{}) uint,
+) CFetchCloser {
+	if hashFunc == nil {
+		hashFunc = hash
+	}
+	return hashBucketedCFetcher{NewBucketedCFetcher(fs), hashFunc}
+}
+
+// CFetch calls one of internal Fetchers
+func (hf hashBucketedCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	return pickBucket(hf.BucketedCFetcher, hf.hash(key)).CFetch(cancel, key)
+}
+
+// CtxFetch calls one of internal Fetchers with context.Context
+func (hf hashBucketedCFetcher) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return ctxFetch(pickBucket(hf.BucketedCFetcher, hf.hash(key)), ctx, key)
 }
 
 // InnerError has been occured in internal Fetcher()
@@ -34,7 +76,13 @@ func (ie InnerError) Error() string {
 	return fmt.Sprintf("%v: %v", ie.Fetcher, ie.Err)
 }
 
-// InnerErrors is a list of InnerError
+// Unwrap returns the original error
+func (ie InnerError) Unwrap() error {
+	return ie.Err
+}
+
+// InnerErrors is a list of InnerError. errors.Is and errors.As look into all
+// of them.
 type InnerErrors []InnerError
 
 func (ies InnerErrors) Error() string {
@@ -45,14 +93,24 @@ func (ies InnerErrors) Error() string {
 	return buf.String()
 }
 
+// Unwrap returns all errors
+func (ies InnerErrors) Unwrap() []error {
+	errs := make([]error, len(ies))
+	for i, ie := range ies {
+		errs[i] = ie
+	}
+	return errs
+}
+
 // Close calls Close() for all internal FetchCloser instances
 func (bf BucketedCFetcher) Close() error {
 	var errs []InnerError
 	for _, f := range bf {
 		switch ff := f.(type) {
 		case io.Closer:
-			err := ff.Close()
-			errs = append(errs, InnerError{f, err})
+			if err := ff.Close(); err != nil {
+				errs = append(errs, InnerError{f, err})
+			}
 		}
 	}
 	if len(errs) > 0 {
@@ -62,20 +120,170 @@ func (bf BucketedCFetcher) Close() error {
 	return nil
 }
 
+// stableHash calculates hash values which are the same among all processes
+func stableHash(s string) uint {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	return uint(h.Sum64())
+}
+
+// keyHasher decides hash values of strings and numbers, which all keys are
+// made of
+type keyHasher struct {
+	str  func(string) uint
+	bits func(uint64) uint
+}
+
+// hashSeed is decided for each process so that nobody can guess the hash
+// values of keys
+var hashSeed = maphash.MakeSeed()
+
+var seededHasher = keyHasher{
+	str: func(s string) uint {
+		return uint(maphash.String(hashSeed, s))
+	},
+	bits: func(x uint64) uint {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], x)
+		return uint(maphash.Bytes(hashSeed, b[:]))
+	},
+}
+
+var stableHasher = keyHasher{
+	str: stableHash,
+	bits: func(x uint64) uint {
+		return mixHash(uint(x))
+	},
+}
+
+// hash is the default hash function for buckets in a process. It uses a
+// random seed for each process, so nobody can choose keys which go to the
+// same bucket.
 func hash(k interface{}) uint {
+	return hashKey(seededHasher, k)
+}
+
+// StableHash calculates hash values of keys which are the same among all
+// processes. It's the default hash function of RingCFetcher, so that the
+// same key goes to the same node in any process. Pointers and channels in
+// keys don't have stable hash values.
+func StableHash(k interface{}) uint {
+	return hashKey(stableHasher, k)
+}
+
+func hashKey(hs keyHasher, k interface{}) uint {
 	switch kk := k.(type) {
 	case Hasher:
 		return kk.Hash()
 	case int:
-		kkk := KInt(kk)
-		return kkk.Hash()
+		return hs.bits(uint64(kk))
+	case int8:
+		return hs.bits(uint64(kk))
+	case int16:
+		return hs.bits(uint64(kk))
+	case int32:
+		return hs.bits(uint64(kk))
+	case int64:
+		return hs.bits(uint64(kk))
+	case uint:
+		return hs.bits(uint64(kk))
+	case uint8:
+		return hs.bits(uint64(kk))
+	case uint16:
+		return hs.bits(uint64(kk))
+	case uint32:
+		return hs.bits(uint64(kk))
+	case uint64:
+		return hs.bits(kk)
+	case uintptr:
+		return hs.bits(uint64(kk))
+	case bool:
+		if kk {
+			return hs.bits(1)
+		}
+		return hs.bits(0)
+	case float32:
+		return hashFloat(hs, float64(kk))
 	case float64:
-		kkk := KFloat64(kk)
-		return kkk.Hash()
+		return hashFloat(hs, kk)
 	case string:
-		return KStr(kk).Hash()
+		return hs.str(kk)
+	}
+	return hashValue(hs, reflect.ValueOf(k))
+}
+
+func hashFloat(hs keyHasher, f float64) uint {
+	if f == 0 {
+		f = 0 // -0 == +0
+	}
+	return hs.bits(math.Float64bits(f))
+}
+
+// hashValue calculates hash values of any comparable values by reflection.
+// Equal values have the same hash value.
+func hashValue(hs keyHasher, v reflect.Value) uint {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return hs.bits(1)
+		}
+		return hs.bits(0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return hs.bits(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return hs.bits(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return hashFloat(hs, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		h := combineHash(fnvOffset, hashFloat(hs, real(c)))
+		return mixHash(uint(combineHash(h, hashFloat(hs, imag(c)))))
+	case reflect.String:
+		return hs.str(v.String())
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		// Lower bits of pointers are always 0 because of the alignment
+		return mixHash(uint(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return hashValue(hs, v.Elem())
+	case reflect.Array:
+		h := uint64(fnvOffset)
+		for i := 0; i < v.Len(); i++ {
+			h = combineHash(h, hashValue(hs, v.Index(i)))
+		}
+		return mixHash(uint(h))
+	case reflect.Struct:
+		h := uint64(fnvOffset)
+		for i := 0; i < v.NumField(); i++ {
+			h = combineHash(h, hashValue(hs, v.Field(i)))
+		}
+		return mixHash(uint(h))
 	}
-	return 0
+	return 0 // Not comparable
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+func combineHash(h uint64, x uint) uint64 {
+	return (h ^ uint64(x)) * fnvPrime
+}
+
+// mixHash spreads hash values over all bits, because hash values of small ints
+// are themselves and combined hash values have weak lower bits.
+func mixHash(h uint) uint {
+	x := uint64(h)
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint(x)
 }
 
 // Hasher provides a function in order to calculate its hash values
@@ -96,6 +304,9 @@ type KFloat64 float64
 
 // Hash calculates hash values
 func (k KFloat64) Hash() uint {
+	if k == 0 {
+		k = 0 // -0 == +0
+	}
 	b := *(*[unsafe.Sizeof(k)]byte)(unsafe.Pointer(&k))
 
 	h := fnv.New64a()
@@ -107,10 +318,7 @@ func (k KFloat64) Hash() uint {
 // KStr is hashable string
 type KStr string
 
-// Hash calculates hash values
+// Hash calculates hash values. They are the same among all processes.
 func (k KStr) Hash() uint {
-	h := fnv.New64a()
-	h.Write([]byte(k))
-
-	return uint(h.Sum64())
+	return stableHash(string(k))
 }
@@ -0,0 +1,171 @@
+package fetchmgr_test
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+type structKey struct {
+	id   int64
+	name string
+	tags [2]uint32
+	ptr  *int
+	any  interface{}
+}
+
+func TestBucketedCFetcherScatter(t *testing.T) {
+	var x int
+	keys := []func(i int) interface{}{
+		func(i int) interface{} { return int64(i) },
+		func(i int) interface{} { return uint32(i) },
+		func(i int) interface{} { return float32(i) },
+		func(i int) interface{} { return [2]int{i, i} },
+		func(i int) interface{} {
+			return structKey{int64(i), "key", [2]uint32{1, 2}, &x, i}
+		},
+	}
+
+	for _, key := range keys {
+		cnts := make([]countCFetcher, 4)
+		fs := make([]CFetcher, len(cnts))
+		for i := range cnts {
+			fs[i] = &cnts[i]
+		}
+		bf := NewBucketedCFetcher(fs)
+
+		for i := 0; i < 100; i++ {
+			_, _ = bf.CFetch(nil, key(i))
+		}
+
+		for i, cnt := range cnts {
+			if cnt == 0 {
+				t.Fatalf("Bucket %d isn't used for %T", i, key(0))
+			}
+		}
+	}
+}
+
+func TestBucketedCFetcherPointers(t *testing.T) {
+	keys := make([]*int, 1000)
+	for i := range keys {
+		keys[i] = new(int)
+	}
+
+	for _, n := range []int{8, 10, 64} {
+		cnts := make([]countCFetcher, n)
+		fs := make([]CFetcher, n)
+		for i := range cnts {
+			fs[i] = &cnts[i]
+		}
+		bf := NewBucketedCFetcher(fs)
+
+		for _, k := range keys {
+			_, _ = bf.CFetch(nil, k)
+		}
+
+		for i, cnt := range cnts {
+			if cnt == 0 {
+				t.Fatalf("Bucket %d of %d isn't used for pointers", i, n)
+			}
+		}
+	}
+}
+
+func TestBucketedCFetcherStable(t *testing.T) {
+	bools := make([]countCFetcher, 2)
+	bf := NewBucketedCFetcher([]CFetcher{&bools[0], &bools[1]})
+	for i := 0; i < 10; i++ {
+		_, _ = bf.CFetch(nil, true)
+		_, _ = bf.CFetch(nil, struct{ a, b string }{"a", "b"})
+	}
+
+	for i, cnt := range bools {
+		if cnt != 0 && cnt != 10 && cnt != 20 {
+			t.Fatalf("Bucket %d gets %d calls, equal keys are scattered", i, cnt)
+		}
+	}
+}
+
+func TestBucketedCFetcherWithHash(t *testing.T) {
+	cnts := make([]countCFetcher, 3)
+	fs := []CFetcher{&cnts[0], &cnts[1], &cnts[2]}
+	bf := NewBucketedCFetcherWithHash(fs, func(k interface{}) uint {
+		return uint(len(k.(string)))
+	})
+	defer bf.Close()
+
+	for _, k := range []string{"a", "b", "cd", "efg", "hij"} {
+		_, _ = bf.CFetch(nil, k)
+	}
+
+	for i, want := range []countCFetcher{2, 2, 1} {
+		if cnts[i] != want {
+			t.Fatalf("Bucket %d gets %d calls, wants %d", i, cnts[i], want)
+		}
+	}
+}
+
+func TestSetHashFunc(t *testing.T) {
+	var called int32
+	cached := New(constFetcher(0), SetHashFunc(func(k interface{}) uint {
+		atomic.AddInt32(&called, 1)
+		return 0
+	}))
+	defer cached.Close()
+
+	v, err := cached.Fetch("key")
+	if err != nil || v != "const" {
+		t.Fatalf(`Gets (%v, %v), wants ("const", nil)`, v, err)
+	}
+	if n := atomic.LoadInt32(&called); n != 1 {
+		t.Fatalf("Gets %d calls of the hash function, wants 1", n)
+	}
+}
+
+func TestBucketedCFetcherIntMultiples(t *testing.T) {
+	fs := make([]countCFetcher, 10)
+	cfs := make([]CFetcher, len(fs))
+	for i := range fs {
+		cfs[i] = &fs[i]
+	}
+	bf := NewBucketedCFetcher(cfs)
+
+	for i := 0; i < 1000; i++ {
+		_, _ = bf.CFetch(nil, i*len(fs))
+	}
+
+	for i, cnt := range fs {
+		if cnt == 0 {
+			t.Fatalf("Bucket %d gets no calls, wants some", i)
+		}
+	}
+}
+
+func TestStableHash(t *testing.T) {
+	f := fnv.New64a()
+	f.Write([]byte("abc"))
+	if h, want := StableHash("abc"), uint(f.Sum64()); h != want {
+		t.Fatalf("Gets %x, wants %x", h, want)
+	}
+	if h, want := StableHash(KStr("abc")), StableHash("abc"); h != want {
+		t.Fatalf("Gets %x, wants %x", h, want)
+	}
+	if h, want := StableHash(int64(42)), StableHash(uint8(42)); h != want {
+		t.Fatalf("Gets %x, wants %x", h, want)
+	}
+	if h := StableHash(42); h == 42 {
+		t.Fatalf("Gets %x, wants a mixed value", h)
+	}
+}
+
+func TestSetHashFuncNil(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Gets no panic, wants a panic")
+		}
+	}()
+	SetHashFunc(nil)
+}
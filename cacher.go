@@ -1,10 +1,13 @@
 package fetchmgr
 
 import (
-	"container/heap"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,35 +16,101 @@ import (
 type CachedCFetcher struct {
 	fetcher  CFetcher
 	ttl      time.Duration
-	interval time.Duration
+	staleTTL time.Duration
+	inline   bool
+	recovers bool
+	owned    bool
+	observer CtxObserver
 	mutex    sync.Mutex
-	cache    map[interface{}]entry
-	queMutex sync.Mutex
-	queue    deleteQueue
-	awake    chan struct{}
+	draining bool
+	inflight sync.WaitGroup
+	cache    sync.Map // interface{} -> *entry
+	stale    map[interface{}]staleEntry
+	wheel    *timingWheel
 	closed   chan struct{}
+	ctx      context.Context
+	stop     context.CancelFunc
+	once     sync.Once
+	err      error
 }
 
+// entry is a cached value. done is closed when val and err are ready.
 type entry struct {
-	value func(<-chan struct{}) (interface{}, error)
+	done       chan struct{}
+	val        interface{}
+	err        error
+	old        staleEntry
+	revalidate bool
 }
 
-// NewCachedCFetcher creates CachedCFetcher
+// staleEntry is an expired value which is held to revalidate it
+type staleEntry struct {
+	value  interface{}
+	expire time.Time
+}
+
+// Expirer is a value which decides its own expiration time. CachedCFetcher
+// uses it instead of its TTL when the fetched value implements Expirer.
+// A zero time means the default TTL.
+type Expirer interface {
+	Expires() time.Time
+}
+
+// Revalidator is a CFetcher which can check whether an expired value is
+// still valid. CachedCFetcher keeps expired values for a while (see
+// SetStaleTTL), and calls Revalidate instead of CFetch to refresh them.
+// If changed is false, the old value is cached again and the returned value
+// is only used to decide the expiration time. Wrappers in this package
+// forward Revalidate if their internal fetchers are Revalidator.
+type Revalidator interface {
+	CFetcher
+	Revalidate(cancel <-chan struct{}, key interface{}, old interface{}) (value interface{}, changed bool, err error)
+}
+
+// NewCachedCFetcher creates CachedCFetcher. It owns fetcher, so Close closes
+// fetcher if it's an io.Closer.
 func NewCachedCFetcher(
 	fetcher CFetcher,
 	ttl time.Duration,
 	interval time.Duration,
 ) *CachedCFetcher {
+	setting := newFetcherSetting()
+	setting.ttl = ttl
+	setting.interval = interval
+	return newCachedCFetcher(fetcher, setting)
+}
+
+func newCachedCFetcher(fetcher CFetcher, setting *fetcherSetting) *CachedCFetcher {
+	wheel := newTimingWheel(setting.interval, 1, setting.clock)
+	cached := newSharedCachedCFetcher(fetcher, setting, wheel)
+	cached.owned = true
+	return cached
+}
+
+// newSharedCachedCFetcher creates CachedCFetcher which shares wheel and
+// fetcher with other instances. It doesn't close fetcher.
+func newSharedCachedCFetcher(
+	fetcher CFetcher,
+	setting *fetcherSetting,
+	wheel *timingWheel,
+) *CachedCFetcher {
+	staleTTL := setting.staleTTL
+	if staleTTL == 0 {
+		staleTTL = setting.ttl
+	}
+
 	cached := &CachedCFetcher{
 		fetcher:  fetcher,
-		ttl:      ttl,
-		interval: interval,
-		cache:    make(map[interface{}]entry),
-		awake:    make(chan struct{}, 1),
+		ttl:      setting.ttl,
+		staleTTL: staleTTL,
+		inline:   setting.inline,
+		recovers: setting.recovers,
+		observer: setting.observer,
+		wheel:    wheel,
+		stale:    make(map[interface{}]staleEntry),
 		closed:   make(chan struct{}),
 	}
-
-	go deleteLoop(cached)
+	cached.ctx, cached.stop = context.WithCancel(context.Background())
 
 	return cached
 }
@@ -52,173 +121,415 @@ func NewCachedCFetcher(
 // If the internal Fetcher.Fetch returns err (!= nil), CachedCFetcher doesn't
 // cache any results.
 func (c *CachedCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
-	e := pickEntry(c, key)
-	return e.value(cancel)
+	return fetchEntry(c, context.Background(), cancel, key)
 }
 
-// Close closes this instance
-func (c *CachedCFetcher) Close() error {
-	close(c.closed)
+// CtxFetch is CFetch with context.Context. The internal fetcher is called
+// with a context which holds values of ctx, but which is canceled only when
+// this instance is closed, because other callers will share the result.
+func (c *CachedCFetcher) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return fetchEntry(c, ctx, ctx.Done(), key)
+}
 
-	fc, ok := c.fetcher.(io.Closer)
-	if ok {
-		err := fc.Close()
-		if err != nil {
-			return err
+// Close closes this instance. It's safe to call Close multiple times, and
+// they return the same error.
+func (c *CachedCFetcher) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.stop()
+		releaseWheel(c.wheel)
+
+		fc, ok := c.fetcher.(io.Closer)
+		if ok && c.owned {
+			c.err = fc.Close()
 		}
-	}
+	})
 
-	return nil
+	return c.err
 }
 
 // ErrFetcherClosed means the underlying fetcher has been closed
 var ErrFetcherClosed = errors.New("fetcher has been already closed")
 
-func pickEntry(c *CachedCFetcher, key interface{}) entry {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	cached, ok := c.cache[key]
-	if ok {
-		return cached
-	}
+// closedEntry is returned for misses after Shutdown is called
+var closedEntry = func() *entry {
+	e := &entry{done: make(chan struct{}), err: ErrFetcherClosed}
+	close(e.done)
+	return e
+}()
+
+// PanicError is returned to callers waiting for the key when the underlying
+// fetcher panics, so that they don't hang. The panic goes on unless
+// SetRecoverPanics is set.
+type PanicError struct {
+	Value interface{}
+	Stack []byte // The stack trace of the panicking goroutine
+}
 
-	var val interface{}
-	var err error
-	done := make(chan struct{})
-	go func() {
-		val, err = c.fetcher.CFetch(c.closed, key)
-		close(done)
+func (pe PanicError) Error() string {
+	return fmt.Sprintf("fetcher panicked: %v", pe.Value)
+}
 
-		if err != nil {
-			// Don't reuse error values
-			deleteKeys(c, key)
-			return
-		}
+// Unwrap returns the value of the panic if it is an error
+func (pe PanicError) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
 
-		queueKey(c, key, c.ttl)
-	}()
+// errHandedOver means the caller who was fetching the value has canceled, so
+// one of the waiters should fetch it again
+var errHandedOver = errors.New("fetch has been handed over")
 
-	lazy := func(cancel <-chan struct{}) (interface{}, error) {
-		select {
-		case <-done:
-			return val, err
-		case <-cancel:
+func fetchEntry(
+	c *CachedCFetcher,
+	ctx context.Context,
+	cancel <-chan struct{},
+	key interface{},
+) (interface{}, error) {
+	for {
+		e, created := pickEntry(c, ctx, key)
+		if !created {
+			observeEntry(c, ctx, key, e)
+		} else if c.inline && !fetchInline(c, ctx, cancel, key, e) {
+			observeCanceled(c, ctx, key)
 			return nil, ErrFetchCanceled
-		case <-c.closed:
-			return nil, ErrFetcherClosed
 		}
+
+		v, err := waitEntry(c, e, cancel)
+		if err == ErrFetchCanceled {
+			observeCanceled(c, ctx, key)
+		}
+		if err != errHandedOver {
+			return v, err
+		}
+		// The first caller has gone. Take over its work.
 	}
+}
 
-	cached = entry{value: lazy}
-	c.cache[key] = cached
+// observeEntry tells the observer whether e has the value or is being
+// fetched
+func observeEntry(c *CachedCFetcher, ctx context.Context, key interface{}, e *entry) {
+	if c.observer == nil || e == closedEntry {
+		return
+	}
 
-	return cached
+	select {
+	case <-e.done:
+		c.observer.Hit(ctx, key)
+	default:
+		c.observer.SharedWait(ctx, key)
+	}
 }
 
-func deleteKeys(c *CachedCFetcher, keys ...interface{}) {
-	if len(keys) == 0 {
-		return // Lock nothing
+func observeCanceled(c *CachedCFetcher, ctx context.Context, key interface{}) {
+	if c.observer != nil {
+		c.observer.Canceled(ctx, key)
+	}
+}
+
+func waitEntry(c *CachedCFetcher, e *entry, cancel <-chan struct{}) (interface{}, error) {
+	select {
+	case <-e.done:
+		return e.val, e.err
+	case <-cancel:
+		return nil, ErrFetchCanceled
+	case <-c.closed:
+		return nil, ErrFetcherClosed
+	}
+}
+
+// pickEntry returns the entry for key. It returns true as well if the entry
+// is created by this call. The caller should fetch the value by fetchInline
+// if c.inline is true.
+func pickEntry(c *CachedCFetcher, ctx context.Context, key interface{}) (*entry, bool) {
+	// Hits don't take any locks
+	if cached, ok := c.cache.Load(key); ok {
+		return cached.(*entry), false
 	}
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	for _, k := range keys {
-		delete(c.cache, k)
+	// Another goroutine may have started fetching key
+	if cached, ok := c.cache.Load(key); ok {
+		return cached.(*entry), false
 	}
-}
 
-func queueKey(c *CachedCFetcher, key interface{}, ttl time.Duration) {
-	c.queMutex.Lock()
-	defer c.queMutex.Unlock()
+	if c.draining {
+		return closedEntry, false // Shutdown has been called
+	}
 
-	item := deleteItem{key, time.Now().Add(ttl)}
-	heap.Push(&c.queue, item)
+	e := &entry{done: make(chan struct{})}
+	c.inflight.Add(1)
+	e.old, e.revalidate = c.stale[key]
+	delete(c.stale, key)
+	c.cache.Store(key, e)
 
-	if item == c.queue[0] {
-		// `item` expires first, so we must readjust sleep time
-		awakeLoop(c)
+	if c.inline {
+		return e, true
 	}
+
+	fctx, stopFetch := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		defer stopFetch()
+		stop := context.AfterFunc(c.ctx, stopFetch)
+		defer stop()
+
+		val, ttlValue, err := fetchValue(c, fctx, key, e)
+		finishEntry(c, key, e, val, ttlValue, err)
+	}()
+
+	return e, true
 }
 
-func deleteLoop(c *CachedCFetcher) {
-Loop:
-	for {
-		willDelete := make([]interface{}, 0, 1) // Will delete a few keys
-
-		c.queMutex.Lock()
-		for c.queue.Len() > 0 {
-			item := heap.Pop(&c.queue).(deleteItem)
-			if item.expire.After(time.Now()) {
-				untilNext := item.expire.Sub(time.Now())
-				heap.Push(&c.queue, item)
-				go func() {
-					t := time.NewTimer(untilNext)
-					select {
-					case <-c.closed:
-						return
-					case <-t.C:
-					}
-					awakeLoop(c)
-				}()
-				break
-			}
-			willDelete = append(willDelete, item.key)
-		}
-		c.queMutex.Unlock()
+// fetchInline fetches the value of e on the caller's goroutine. If the caller
+// cancels, it stops fetching and returns false, then waiters fetch it again.
+func fetchInline(
+	c *CachedCFetcher,
+	ctx context.Context,
+	cancel <-chan struct{},
+	key interface{},
+	e *entry,
+) bool {
+	if cancel != nil && cancel != ctx.Done() {
+		return fetchInlineChan(c, ctx, cancel, key, e)
+	}
 
-		// Delete here to avoid a dead lock
-		deleteKeys(c, willDelete...)
+	fctx, stopFetch := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopFetch()
+	stop := context.AfterFunc(c.ctx, stopFetch)
+	defer stop()
+
+	canceled := new(int32)
+	if cancel != nil {
+		stopAbort := context.AfterFunc(ctx, func() {
+			atomic.StoreInt32(canceled, 1)
+			stopFetch()
+		})
+		defer stopAbort()
+	}
 
-		t := time.NewTimer(c.interval)
-		select {
-		case <-c.closed:
-			break Loop
-		case <-t.C: // Sleep a specified interval at least
-		}
+	val, ttlValue, err := fetchValue(c, fctx, key, e)
+	if err != nil && atomic.LoadInt32(canceled) == 1 {
+		handOver(c, key, e)
+		return false
+	}
+	if err != nil && c.ctx.Err() != nil {
+		err = ErrFetcherClosed
+	}
 
-		select {
-		case <-c.closed:
-			break Loop
-		case <-c.awake:
-		}
+	finishEntry(c, key, e, val, ttlValue, err)
+	return true
+}
+
+// fetchInlineChan is fetchInline for cancel channels which aren't
+// ctx.Done(). Watching both cancel and c.ctx takes a goroutine, so the
+// fetcher gets cancel as it is, and Close doesn't interrupt it.
+func fetchInlineChan(
+	c *CachedCFetcher,
+	ctx context.Context,
+	cancel <-chan struct{},
+	key interface{},
+	e *entry,
+) bool {
+	fctx := doneContext{context.WithoutCancel(ctx), cancel}
+
+	val, ttlValue, err := fetchValue(c, fctx, key, e)
+	if err != nil && fctx.Err() != nil {
+		handOver(c, key, e)
+		return false
+	}
+	if err != nil && c.ctx.Err() != nil {
+		err = ErrFetcherClosed
 	}
+
+	finishEntry(c, key, e, val, ttlValue, err)
+	return true
+}
+
+// doneContext is canceled when done is closed. Fetchers which take cancel
+// channels get done without any goroutines.
+type doneContext struct {
+	context.Context
+	done <-chan struct{}
+}
+
+func (dc doneContext) Done() <-chan struct{} {
+	return dc.done
 }
 
-func awakeLoop(c *CachedCFetcher) {
+func (dc doneContext) Err() error {
 	select {
-	case c.awake <- struct{}{}:
+	case <-dc.done:
+		return context.Canceled
 	default:
+		return nil
 	}
 }
 
-type deleteItem struct {
-	key    interface{}
-	expire time.Time
+// fetchValue returns the value to cache and the value to decide its
+// expiration time
+func fetchValue(
+	c *CachedCFetcher,
+	ctx context.Context,
+	key interface{},
+	e *entry,
+) (val interface{}, ttlValue interface{}, err error) {
+	if c.observer != nil {
+		c.observer.FetchStart(ctx, key)
+		start := c.wheel.clock.Now()
+		defer func() {
+			c.observer.FetchEnd(ctx, key, c.wheel.clock.Now().Sub(start), err)
+		}()
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		val, ttlValue, err = nil, nil, PanicError{r, debug.Stack()}
+		if !c.recovers {
+			// Wake up waiters, then panic on the stack of the fetcher
+			finishEntry(c, key, e, nil, nil, err)
+			panic(r)
+		}
+	}()
+
+	if e.revalidate {
+		return revalidateValue(c, ctx, key, e.old.value)
+	}
+	val, err = ctxFetch(c.fetcher, ctx, key)
+	return val, val, err
 }
 
-type deleteQueue []deleteItem
+func finishEntry(
+	c *CachedCFetcher,
+	key interface{},
+	e *entry,
+	val interface{},
+	ttlValue interface{},
+	err error,
+) {
+	e.val, e.err = val, err
+	e.old = staleEntry{}
+
+	// Schedule the expiration before waking up callers, so that they can
+	// advance the clock right after they get the value
+	if err != nil || c.ttl <= 0 {
+		// Don't reuse error values, nor values without TTL
+		c.mutex.Lock()
+		c.cache.CompareAndDelete(key, e)
+		c.mutex.Unlock()
+	} else {
+		queueKey(c, key, val, valueTTL(c, ttlValue))
+	}
 
-func (dq deleteQueue) Len() int { return len(dq) }
+	close(e.done)
+	c.inflight.Done()
+}
 
-func (dq deleteQueue) Less(i, j int) bool {
-	return dq[i].expire.Before(dq[j].expire)
+// handOver removes e and wakes up its waiters to fetch the value again
+func handOver(c *CachedCFetcher, key interface{}, e *entry) {
+	c.mutex.Lock()
+	c.cache.CompareAndDelete(key, e)
+	if e.revalidate && c.wheel.clock.Now().Before(e.old.expire) {
+		// Keep the stale value for the next caller
+		c.stale[key] = e.old
+	}
+	c.mutex.Unlock()
+
+	e.old = staleEntry{}
+	e.err = errHandedOver
+	close(e.done)
+	c.inflight.Done()
+}
+
+// revalidateValue returns the value to cache and the value to decide its
+// expiration time
+func revalidateValue(
+	c *CachedCFetcher,
+	ctx context.Context,
+	key interface{},
+	old interface{},
+) (interface{}, interface{}, error) {
+	r, _ := RevalidatorOf(c.fetcher) // Only Revalidator has stale values
+	v, changed, err := r.Revalidate(ctx.Done(), key, old)
+	if err != nil || changed {
+		return v, v, err
+	}
+	return old, v, nil
 }
 
-func (dq deleteQueue) Swap(i, j int) {
-	dq[i], dq[j] = dq[j], dq[i]
+func valueTTL(c *CachedCFetcher, v interface{}) time.Duration {
+	e, ok := v.(Expirer)
+	if !ok {
+		return c.ttl
+	}
+
+	expire := e.Expires()
+	if expire.IsZero() {
+		return c.ttl
+	}
+	return expire.Sub(c.wheel.clock.Now())
 }
 
-func (dq *deleteQueue) Push(x interface{}) {
-	*dq = append(*dq, x.(deleteItem))
+func queueKey(c *CachedCFetcher, key interface{}, value interface{}, ttl time.Duration) {
+	queueItem(c, deleteItem{key, c.wheel.clock.Now().Add(ttl), value, false})
 }
 
-func (dq *deleteQueue) Pop() interface{} {
-	n := len(*dq)
-	ret := (*dq)[n-1]
-	*dq = (*dq)[0 : n-1]
-	return ret
+func queueItem(c *CachedCFetcher, item deleteItem) {
+	addItem(c.wheel, c, item)
+}
+
+// expireItems deletes expired keys. Values for Revalidator are kept for
+// c.staleTTL as stale values.
+func expireItems(c *CachedCFetcher, items []deleteItem) {
+	if len(items) == 0 {
+		return // Lock nothing
+	}
+
+	_, revalidator := RevalidatorOf(c.fetcher)
+	var staleItems []deleteItem
+	var evicted []interface{}
+
+	now := c.wheel.clock.Now()
+	c.mutex.Lock()
+	for _, item := range items {
+		if item.stale {
+			s, ok := c.stale[item.key]
+			if ok && s.expire.Equal(item.expire) {
+				delete(c.stale, item.key)
+			}
+			continue
+		}
+
+		c.cache.Delete(item.key)
+		evicted = append(evicted, item.key)
+		if revalidator {
+			s := deleteItem{item.key, now.Add(c.staleTTL), nil, true}
+			c.stale[item.key] = staleEntry{item.value, s.expire}
+			staleItems = append(staleItems, s)
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, item := range staleItems {
+		queueItem(c, item)
+	}
+
+	if c.observer != nil {
+		for _, key := range evicted {
+			c.observer.Evict(context.Background(), key)
+		}
+	}
+}
+
+type deleteItem struct {
+	key    interface{}
+	expire time.Time
+	value  interface{}
+	stale  bool
 }
 
 // CachedFetcher caches fetched contents. It use Fetcher internally to fetch
@@ -234,7 +545,7 @@ func NewCachedFetcher(
 	ttl time.Duration,
 	interval time.Duration,
 ) CachedFetcher {
-	cfetcher := AsCFetcher{fetcher}
+	cfetcher := asCFetcher(fetcher)
 	ccfetcher := NewCachedCFetcher(cfetcher, ttl, interval)
 	return CachedFetcher{
 		ccfetcher,
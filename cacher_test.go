@@ -1,6 +1,9 @@
 package fetchmgr_test
 
 import (
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,3 +31,222 @@ func TestClose(t *testing.T) {
 		t.Fatalf("Gets (%v, %v), wants ErrFetcherClosed", v, err)
 	}
 }
+
+type document struct {
+	version int
+	body    string
+}
+
+type versionedFetcher struct {
+	version      int32
+	fetches      int32
+	revalidation int32
+}
+
+func (vf *versionedFetcher) Fetch(key interface{}) (interface{}, error) {
+	atomic.AddInt32(&vf.fetches, 1)
+	v := int(atomic.LoadInt32(&vf.version))
+	return &document{v, fmt.Sprintf("%v version %d", key, v)}, nil
+}
+
+func (vf *versionedFetcher) Revalidate(key interface{}, old interface{}) (interface{}, bool, error) {
+	atomic.AddInt32(&vf.revalidation, 1)
+	if old.(*document).version == int(atomic.LoadInt32(&vf.version)) {
+		return nil, false, nil
+	}
+	v, err := vf.Fetch(key)
+	return v, true, err
+}
+
+func TestRevalidator(t *testing.T) {
+	vf := &versionedFetcher{}
+	cached := New(
+		vf,
+		SetBucketNum(1),
+		SetTTL(5*time.Millisecond),
+		SetInterval(time.Millisecond),
+		SetStaleTTL(time.Minute),
+	)
+	defer cached.Close()
+
+	first, _ := cached.Fetch("doc")
+	time.Sleep(20 * time.Millisecond) // Wait for expiration
+
+	second, _ := cached.Fetch("doc")
+	if first != second {
+		t.Fatalf("Gets %v, wants the old value %v", second, first)
+	}
+
+	atomic.StoreInt32(&vf.version, 1)
+	time.Sleep(20 * time.Millisecond) // Wait for expiration
+
+	third, _ := cached.Fetch("doc")
+	if d := third.(*document); d.version != 1 {
+		t.Fatalf("Gets version %d, wants 1", d.version)
+	}
+
+	if n := atomic.LoadInt32(&vf.revalidation); n != 2 {
+		t.Fatalf("Gets %d revalidations, wants 2", n)
+	}
+	if n := atomic.LoadInt32(&vf.fetches); n != 2 {
+		t.Fatalf("Gets %d fetches, wants 2", n)
+	}
+}
+
+type gateFetcher struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (gf *gateFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	atomic.AddInt32(&gf.calls, 1)
+	select {
+	case <-gf.release:
+		return key, nil
+	case <-cancel:
+		return nil, errors.New("canceled")
+	}
+}
+
+func waitCalls(t *testing.T, gf *gateFetcher, n int32) {
+	for i := 0; atomic.LoadInt32(&gf.calls) != n; i++ {
+		if i >= 1000 {
+			t.Fatalf("Gets %d calls, wants %d", atomic.LoadInt32(&gf.calls), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInlineFetch(t *testing.T) {
+	gf := &gateFetcher{release: make(chan struct{})}
+	ccf := CNew(gf, SetInlineFetch(true))
+	defer ccf.Close()
+
+	cancel1 := make(chan struct{})
+	errs1 := make(chan error)
+	go func() {
+		_, err := ccf.CFetch(cancel1, "key")
+		errs1 <- err
+	}()
+	waitCalls(t, gf, 1)
+
+	rets2 := make(chan interface{})
+	go func() {
+		v, _ := ccf.CFetch(nil, "key")
+		rets2 <- v
+	}()
+
+	cancel3 := make(chan struct{})
+	close(cancel3)
+	if _, err := ccf.CFetch(cancel3, "key"); err != ErrFetchCanceled {
+		t.Fatalf("Gets %v, wants ErrFetchCanceled", err)
+	}
+
+	// The second caller takes over the fetch
+	close(cancel1)
+	if err := <-errs1; err != ErrFetchCanceled {
+		t.Fatalf("Gets %v, wants ErrFetchCanceled", err)
+	}
+	waitCalls(t, gf, 2)
+
+	close(gf.release)
+	if v := <-rets2; v != "key" {
+		t.Fatalf(`Gets %v, wants "key"`, v)
+	}
+
+	v, err := ccf.CFetch(nil, "key")
+	if err != nil || v != "key" {
+		t.Fatalf(`Gets (%v, %v), wants ("key", nil)`, v, err)
+	}
+	if n := atomic.LoadInt32(&gf.calls); n != 2 {
+		t.Fatalf("Gets %d calls, wants 2", n)
+	}
+}
+
+func TestInlineFetchClose(t *testing.T) {
+	gf := &gateFetcher{release: make(chan struct{})}
+	ccf := CNew(gf, SetInlineFetch(true))
+
+	go func() {
+		waitCalls(t, gf, 1)
+		ccf.Close()
+	}()
+
+	if _, err := ccf.CFetch(nil, "key"); err != ErrFetcherClosed {
+		t.Fatalf("Gets %v, wants ErrFetcherClosed", err)
+	}
+}
+
+func TestZeroTTL(t *testing.T) {
+	gf := &gateFetcher{release: make(chan struct{})}
+	ccf := CNew(gf, SetTTL(0))
+	defer ccf.Close()
+
+	done := make(chan interface{}, 2)
+	fetch := func() {
+		v, _ := ccf.CFetch(nil, "key")
+		done <- v
+	}
+	go fetch()
+	waitCalls(t, gf, 1)
+	go fetch()
+	time.Sleep(10 * time.Millisecond) // Wait for the 2nd call to join
+
+	close(gf.release)
+	for i := 0; i < 2; i++ {
+		if v := <-done; v != "key" {
+			t.Fatalf(`Gets %v, wants "key"`, v)
+		}
+	}
+	if n := atomic.LoadInt32(&gf.calls); n != 1 {
+		t.Fatalf("Gets %d calls, wants 1 (shared by concurrent calls)", n)
+	}
+
+	ccf.CFetch(nil, "key")
+	if n := atomic.LoadInt32(&gf.calls); n != 2 {
+		t.Fatalf("Gets %d calls, wants 2 (not cached)", n)
+	}
+}
+
+// panicFetcher panics after release is closed
+type panicFetcher struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (pf *panicFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	atomic.AddInt32(&pf.calls, 1)
+	<-pf.release
+	panic("boom")
+}
+
+func TestPanicGoesOn(t *testing.T) {
+	pf := &panicFetcher{release: make(chan struct{})}
+	ccf := CNew(pf, SetInlineFetch(true))
+	defer ccf.Close()
+
+	recovered := make(chan interface{})
+	go func() {
+		defer func() { recovered <- recover() }()
+		ccf.CFetch(nil, "key")
+	}()
+	for atomic.LoadInt32(&pf.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	waited := make(chan error)
+	go func() {
+		_, err := ccf.CFetch(nil, "key")
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // Wait for the 2nd call to join
+
+	close(pf.release)
+	if r := <-recovered; r != "boom" {
+		t.Fatalf(`Gets %v, wants the panic "boom"`, r)
+	}
+	var pe PanicError
+	if err := <-waited; !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Fatalf("Gets %v, wants PanicError for the waiter", err)
+	}
+}
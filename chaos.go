@@ -0,0 +1,224 @@
+package fetchmgr
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the default error which ChaosCFetcher injects
+var ErrInjected = errors.New("injected failure")
+
+// ErrInjectedPanic is the value which ChaosCFetcher panics with
+var ErrInjectedPanic = errors.New("injected panic")
+
+// Latency draws latencies from a distribution
+type Latency func(r *rand.Rand) time.Duration
+
+// FixedLatency always returns d
+func FixedLatency(d time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency returns latencies uniformly distributed in [min, max)
+func UniformLatency(min, max time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// NormalLatency returns normally distributed latencies. Negative values are
+// rounded to 0.
+func NormalLatency(mean, stddev time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		d := time.Duration(r.NormFloat64()*float64(stddev)) + mean
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// ExponentialLatency returns exponentially distributed latencies, which
+// sometimes have a long tail
+func ExponentialLatency(mean time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// ChaosCFetcher injects faults into the internal CFetcher: latencies, errors,
+// hangs and panics. Faults are drawn from the seeded source, so sequential
+// calls fail the same way in every run.
+type ChaosCFetcher struct {
+	wrapped
+	latency   Latency
+	errorRate float64
+	err       error
+	hangRate  float64
+	panicRate float64
+	mutex     sync.Mutex
+	rand      *rand.Rand
+}
+
+type chaosSetting struct {
+	latency   Latency
+	errorRate float64
+	err       error
+	hangRate  float64
+	panicRate float64
+}
+
+// ChaosSetting makes arguments for NewChaosCFetcher constracter
+type ChaosSetting func(*chaosSetting)
+
+// SetLatency adds latencies drawn from l to every call
+func SetLatency(l Latency) ChaosSetting {
+	return func(cs *chaosSetting) {
+		cs.latency = l
+	}
+}
+
+// SetErrorRate makes the ratio p of calls return err. ErrInjected is used if
+// err is nil.
+func SetErrorRate(p float64, err error) ChaosSetting {
+	return func(cs *chaosSetting) {
+		cs.errorRate = p
+		cs.err = err
+	}
+}
+
+// SetHangRate makes the ratio p of calls hang until they are canceled.
+// They return ErrFetchCanceled. Calls with nil cancel hang forever.
+func SetHangRate(p float64) ChaosSetting {
+	return func(cs *chaosSetting) {
+		cs.hangRate = p
+	}
+}
+
+// SetPanicRate makes the ratio p of calls panic with ErrInjectedPanic.
+// CachedCFetcher with SetRecoverPanics recovers them and returns PanicError.
+func SetPanicRate(p float64) ChaosSetting {
+	return func(cs *chaosSetting) {
+		cs.panicRate = p
+	}
+}
+
+// NewChaosCFetcher creates ChaosCFetcher which draws faults with seed
+func NewChaosCFetcher(fetcher CFetcher, seed int64, ss ...ChaosSetting) *ChaosCFetcher {
+	setting := &chaosSetting{
+		latency: FixedLatency(0),
+		err:     ErrInjected,
+	}
+
+	for _, set := range ss {
+		set(setting)
+	}
+	if setting.err == nil {
+		setting.err = ErrInjected
+	}
+
+	return &ChaosCFetcher{
+		wrapped:   wrapped{fetcher},
+		latency:   setting.latency,
+		errorRate: setting.errorRate,
+		err:       setting.err,
+		hangRate:  setting.hangRate,
+		panicRate: setting.panicRate,
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+type fault int
+
+const (
+	noFault fault = iota
+	hangFault
+	panicFault
+	errorFault
+)
+
+// CFetch waits for the latency and calls the internal CFetcher unless it
+// injects a fault
+func (cf *ChaosCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	if err := injectFault(cf, cancel); err != nil {
+		return nil, err
+	}
+	return cf.fetcher.CFetch(cancel, key)
+}
+
+// Revalidate injects faults as CFetch does, and revalidates the old value by
+// the internal CFetcher
+func (cf *ChaosCFetcher) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	if err := injectFault(cf, cancel); err != nil {
+		return nil, false, err
+	}
+	return revalidate(cf.fetcher, cancel, key, old)
+}
+
+// injectFault waits for the latency and injects the fault of a call
+func injectFault(cf *ChaosCFetcher, cancel <-chan struct{}) error {
+	latency, f := drawFault(cf)
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		select {
+		case <-t.C:
+		case <-cancel:
+			t.Stop()
+			return ErrFetchCanceled
+		}
+	}
+
+	switch f {
+	case hangFault:
+		<-cancel
+		return ErrFetchCanceled
+	case panicFault:
+		panic(ErrInjectedPanic)
+	case errorFault:
+		return cf.err
+	}
+
+	return nil
+}
+
+// drawFault draws the latency and the fault of a call. It always draws both
+// to keep the sequence reproducible.
+func drawFault(cf *ChaosCFetcher) (time.Duration, fault) {
+	cf.mutex.Lock()
+	defer cf.mutex.Unlock()
+
+	latency := cf.latency(cf.rand)
+	p := cf.rand.Float64()
+	switch {
+	case p < cf.hangRate:
+		return latency, hangFault
+	case p < cf.hangRate+cf.panicRate:
+		return latency, panicFault
+	case p < cf.hangRate+cf.panicRate+cf.errorRate:
+		return latency, errorFault
+	}
+	return latency, noFault
+}
+
+// Close closes the internal CFetcher if it is an io.Closer
+func (cf *ChaosCFetcher) Close() error {
+	fc, ok := cf.fetcher.(io.Closer)
+	if ok {
+		return fc.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package fetchmgr_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func chaosErrors(seed int64, n int) []bool {
+	var cnt countCFetcher
+	cf := NewChaosCFetcher(&cnt, seed, SetErrorRate(0.3, nil))
+
+	errs := make([]bool, n)
+	for i := range errs {
+		_, err := cf.CFetch(nil, i)
+		errs[i] = err == ErrInjected
+	}
+	return errs
+}
+
+func TestChaosCFetcherSeed(t *testing.T) {
+	errs1 := chaosErrors(42, 1000)
+	errs2 := chaosErrors(42, 1000)
+
+	failed := 0
+	for i := range errs1 {
+		if errs1[i] != errs2[i] {
+			t.Fatalf("Gets different results at %d with the same seed", i)
+		}
+		if errs1[i] {
+			failed++
+		}
+	}
+
+	if failed < 250 || failed > 350 {
+		t.Fatalf("Gets %d errors, wants about 300", failed)
+	}
+}
+
+func TestChaosCFetcherFaults(t *testing.T) {
+	var cnt countCFetcher
+	errFault := errors.New("fault")
+
+	cf := NewChaosCFetcher(&cnt, 1, SetErrorRate(1, errFault))
+	if _, err := cf.CFetch(nil, "key"); err != errFault {
+		t.Fatalf("Gets %v, wants errFault", err)
+	}
+
+	cf = NewChaosCFetcher(&cnt, 1, SetHangRate(1))
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(cancel)
+	}()
+	if _, err := cf.CFetch(cancel, "key"); err != ErrFetchCanceled {
+		t.Fatalf("Gets %v, wants ErrFetchCanceled", err)
+	}
+
+	cf = NewChaosCFetcher(&cnt, 1, SetPanicRate(1))
+	func() {
+		defer func() {
+			if r := recover(); r != ErrInjectedPanic {
+				t.Fatalf("Gets %v, wants ErrInjectedPanic", r)
+			}
+		}()
+		cf.CFetch(nil, "key")
+	}()
+
+	cf = NewChaosCFetcher(&cnt, 1, SetLatency(FixedLatency(20*time.Millisecond)))
+	start := time.Now()
+	v, err := cf.CFetch(nil, "key")
+	if err != nil || v != "key" {
+		t.Fatalf(`Gets (%v, %v), wants ("key", nil)`, v, err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("Gets %v, wants 20ms latency", d)
+	}
+
+	if cnt != 1 {
+		t.Fatalf("Gets %d calls, wants 1", cnt)
+	}
+}
+
+func TestChaosCFetcherPanicBehindCache(t *testing.T) {
+	for _, inline := range []bool{false, true} {
+		var cnt countCFetcher
+		cf := NewChaosCFetcher(&cnt, 1, SetPanicRate(1))
+		cached := CNew(cf, SetInlineFetch(inline), SetRecoverPanics(true))
+
+		_, err := cached.CFetch(nil, "key")
+		var pe PanicError
+		if !errors.As(err, &pe) || !errors.Is(err, ErrInjectedPanic) {
+			t.Fatalf("Gets %v, wants PanicError of ErrInjectedPanic", err)
+		}
+		if !bytes.Contains(pe.Stack, []byte("injectFault")) {
+			t.Fatalf("Gets the stack %s, wants the one of the panic", pe.Stack)
+		}
+
+		timeout := make(chan struct{})
+		time.AfterFunc(time.Second, func() { close(timeout) })
+		if err := cached.(Shutdowner).Shutdown(timeout); err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+	}
+}
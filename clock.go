@@ -0,0 +1,137 @@
+package fetchmgr
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time to CachedCFetcher. Use SetClock to replace it.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the timer which Clock.AfterFunc returns
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the timer
+	// has already fired or been stopped.
+	Stop() bool
+}
+
+// systemClock is the default Clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock is a Clock for tests. Its time goes forward only by Advance.
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	seq    int
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	seq   int
+	f     func()
+}
+
+// NewFakeClock creates FakeClock which starts at t
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the current time of the clock
+func (fc *FakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.now
+}
+
+// AfterFunc registers f to call when the clock is advanced by d
+func (fc *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.seq++
+	ft := &fakeTimer{fc, fc.now.Add(d), fc.seq, f}
+	fc.timers = append(fc.timers, ft)
+	return ft
+}
+
+// Advance moves the clock forward by d. Timers fire in order of their time
+// on the caller's goroutine, and Advance returns after all of them finish.
+// Timers which are registered by them fire as well if they are due.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	target := fc.now.Add(d)
+	fc.mutex.Unlock()
+
+	for {
+		ft := nextTimer(fc, target)
+		if ft == nil {
+			break
+		}
+		ft.f()
+	}
+
+	fc.mutex.Lock()
+	fc.now = target
+	fc.mutex.Unlock()
+}
+
+// nextTimer removes the earliest timer due by target, and moves the clock to
+// its time
+func nextTimer(fc *FakeClock, target time.Time) *fakeTimer {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if len(fc.timers) == 0 {
+		return nil
+	}
+
+	sort.Slice(fc.timers, func(i, j int) bool {
+		ti, tj := fc.timers[i], fc.timers[j]
+		if !ti.at.Equal(tj.at) {
+			return ti.at.Before(tj.at)
+		}
+		return ti.seq < tj.seq
+	})
+
+	ft := fc.timers[0]
+	if ft.at.After(target) {
+		return nil
+	}
+
+	fc.timers = fc.timers[1:]
+	if ft.at.After(fc.now) {
+		fc.now = ft.at
+	}
+	return ft
+}
+
+// Stop removes the timer from the clock
+func (ft *fakeTimer) Stop() bool {
+	fc := ft.clock
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	for i, t := range fc.timers {
+		if t == ft {
+			fc.timers = append(fc.timers[:i], fc.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
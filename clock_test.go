@@ -0,0 +1,69 @@
+package fetchmgr_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+
+	var fired []int
+	clk.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	clk.AfterFunc(time.Second, func() {
+		fired = append(fired, 1)
+		clk.AfterFunc(time.Second, func() { fired = append(fired, 3) })
+	})
+	stopped := clk.AfterFunc(time.Second, func() { fired = append(fired, 0) })
+	if !stopped.Stop() {
+		t.Fatalf("Gets false, wants true")
+	}
+
+	clk.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 || fired[0] != 1 {
+		t.Fatalf("Gets %v, wants [1]", fired)
+	}
+
+	clk.Advance(time.Second)
+	if len(fired) != 3 || fired[1] != 2 || fired[2] != 3 {
+		t.Fatalf("Gets %v, wants [1 2 3]", fired)
+	}
+
+	if now := clk.Now(); !now.Equal(start.Add(2500 * time.Millisecond)) {
+		t.Fatalf("Gets %v, wants 2.5s after the start", now)
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	var cnt countCFetcher
+	cached := CNew(
+		&cnt,
+		SetClock(clk),
+		SetTTL(time.Minute),
+		SetInterval(time.Second),
+	)
+	defer cached.Close()
+
+	fetch := func(wants int32) {
+		t.Helper()
+		if _, err := cached.CFetch(nil, "key"); err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+		if n := atomic.LoadInt32((*int32)(&cnt)); n != wants {
+			t.Fatalf("Gets %d fetches, wants %d", n, wants)
+		}
+	}
+
+	fetch(1)
+	clk.Advance(59 * time.Second)
+	fetch(1)
+	clk.Advance(2 * time.Second)
+	fetch(2)
+	clk.Advance(time.Hour)
+	fetch(3)
+}
@@ -0,0 +1,60 @@
+package fetchmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// Codec converts values to bytes to store them outside of processes
+type Codec interface {
+	Encode(interface{}) ([]byte, error)
+	Decode([]byte) (interface{}, error)
+}
+
+// ErrUnsupportedValue means the codec can't encode the value
+var ErrUnsupportedValue = errors.New("unsupported value")
+
+// BytesCodec is the default Codec. It only supports []byte values.
+type BytesCodec struct{}
+
+// Encode encodes values
+func (BytesCodec) Encode(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, ErrUnsupportedValue
+	}
+	return b, nil
+}
+
+// Decode decodes values
+func (BytesCodec) Decode(b []byte) (interface{}, error) {
+	return b, nil
+}
+
+// JSONCodec encodes values into JSON
+type JSONCodec struct {
+	// New makes a pointer to decode values into. Decode returns what the
+	// pointer points to. If it's nil, values are decoded as interface{}.
+	New func() interface{}
+}
+
+// Encode encodes values
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode decodes values
+func (c JSONCodec) Decode(b []byte) (interface{}, error) {
+	if c.New == nil {
+		var v interface{}
+		err := json.Unmarshal(b, &v)
+		return v, err
+	}
+
+	p := c.New()
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(p).Elem().Interface(), nil
+}
@@ -0,0 +1,25 @@
+package fetchmgr_test
+
+import (
+	"testing"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func TestBytesCodec(t *testing.T) {
+	var codec Codec = BytesCodec{}
+
+	b, err := codec.Encode([]byte("value"))
+	if err != nil || string(b) != "value" {
+		t.Fatalf(`Gets (%q, %v), wants ("value", nil)`, b, err)
+	}
+
+	v, err := codec.Decode(b)
+	if err != nil || string(v.([]byte)) != "value" {
+		t.Fatalf(`Gets (%q, %v), wants ("value", nil)`, v, err)
+	}
+
+	if _, err := codec.Encode("value"); err != ErrUnsupportedValue {
+		t.Fatalf("Gets %v, wants ErrUnsupportedValue", err)
+	}
+}
@@ -0,0 +1,108 @@
+package fetchmgr
+
+import (
+	"context"
+	"io"
+)
+
+// CtxFetcher is the interface in order to fetch outer resources with
+// context.Context
+type CtxFetcher interface {
+	Fetch(context.Context, interface{}) (interface{}, error)
+}
+
+// CtxRevalidator is Revalidator for CtxFetcher
+type CtxRevalidator interface {
+	CtxFetcher
+	Revalidate(ctx context.Context, key interface{}, old interface{}) (value interface{}, changed bool, err error)
+}
+
+// CtxFetchCloser has Fetch and Close method
+type CtxFetchCloser interface {
+	CtxFetcher
+	io.Closer
+}
+
+// ctxCFetcher is a CFetcher which also accepts context.Context
+type ctxCFetcher interface {
+	CtxFetch(context.Context, interface{}) (interface{}, error)
+}
+
+// AsCtxFetcher makes CtxFetcher from CFetcher. If CFetcher has the CtxFetch
+// method like CachedCFetcher, ctx is passed as it is. Otherwise, CFetch is
+// called with ctx.Done().
+type AsCtxFetcher struct {
+	CFetcher
+}
+
+// Fetch fetches values
+func (cf AsCtxFetcher) Fetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return ctxFetch(cf.CFetcher, ctx, key)
+}
+
+// FromCtxFetcher makes CFetcher from CtxFetcher. CFetch calls Fetch with a
+// context which is canceled when the cancel chan is closed.
+type FromCtxFetcher struct {
+	CtxFetcher
+}
+
+// CFetch fetches values
+func (cf FromCtxFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	ctx, stop := cancelContext(cancel)
+	defer stop()
+
+	return cf.Fetch(ctx, key)
+}
+
+// Revalidate revalidates the old value if CtxFetcher is CtxRevalidator.
+// Otherwise, it fetches the new value.
+func (cf FromCtxFetcher) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	ctx, stop := cancelContext(cancel)
+	defer stop()
+
+	r, ok := cf.CtxFetcher.(CtxRevalidator)
+	if !ok {
+		v, err := cf.Fetch(ctx, key)
+		return v, true, err
+	}
+	return r.Revalidate(ctx, key, old)
+}
+
+func (cf FromCtxFetcher) canRevalidate() bool {
+	_, ok := cf.CtxFetcher.(CtxRevalidator)
+	return ok
+}
+
+// cancelContext makes a context which is canceled when cancel is closed
+func cancelContext(cancel <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, stop := context.WithCancel(context.Background())
+
+	if cancel != nil {
+		go func() {
+			select {
+			case <-cancel:
+				stop()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return ctx, stop
+}
+
+// CtxFetch fetches values
+func (cf FromCtxFetcher) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return cf.Fetch(ctx, key)
+}
+
+func ctxFetch(f CFetcher, ctx context.Context, key interface{}) (interface{}, error) {
+	cf, ok := f.(ctxCFetcher)
+	if ok {
+		return cf.CtxFetch(ctx, key)
+	}
+	return f.CFetch(ctx.Done(), key)
+}
@@ -0,0 +1,38 @@
+package fetchmgr_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+type waitCtxFetcher struct{}
+
+func (waitCtxFetcher) Fetch(ctx context.Context, key interface{}) (interface{}, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestFromCtxFetcher(t *testing.T) {
+	cf := FromCtxFetcher{waitCtxFetcher{}}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	v, err := cf.CFetch(cancel, "key")
+	if err != context.Canceled {
+		t.Fatalf("Gets (%v, %v), wants context.Canceled", v, err)
+	}
+}
+
+func TestAsCtxFetcher(t *testing.T) {
+	f := AsCtxFetcher{CNew(FromCtxFetcher{waitCtxFetcher{}})}
+	defer f.CFetcher.(CFetchCloser).Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	v, err := f.Fetch(ctx, "key")
+	if err != ErrFetchCanceled {
+		t.Fatalf("Gets (%v, %v), wants ErrFetchCanceled", v, err)
+	}
+}
@@ -2,10 +2,21 @@
 package ctxfetchmgr
 
 import (
+	"context"
+
 	"github.com/hiratara/fetchmgr"
-	"golang.org/x/net/context"
 )
 
+// Observer receives events of caches with the caller's context.
+// See fetchmgr.CtxObserver.
+type Observer = fetchmgr.CtxObserver
+
+// SetObserver sets the observer. Its hooks receive the context passed to
+// CtxFetch, so spans can be parented correctly.
+func SetObserver(o Observer) fetchmgr.Setting {
+	return fetchmgr.SetCtxObserver(o)
+}
+
 // ContextFetcher is a context-aware Fetcher
 type ContextFetcher struct {
 	fetcher fetchmgr.CFetchCloser
@@ -20,17 +31,48 @@ func CNew(
 	return ContextFetcher{cached}
 }
 
+// CtxNew makes the new ContextFetcher from CtxFetcher. The internal fetcher
+// receives values of the context which caused fetching, but it's canceled
+// only when ContextFetcher is closed.
+func CtxNew(
+	fetcher fetchmgr.CtxFetcher,
+	ss ...fetchmgr.Setting,
+) ContextFetcher {
+	cfetcher := fetchmgr.FromCtxFetcher{CtxFetcher: fetcher}
+	return CNew(cfetcher, ss...)
+}
+
 // Close closes underlying fetcher
 func (f ContextFetcher) Close() error {
 	return f.fetcher.Close()
 }
 
+// Shutdown closes underlying fetcher gracefully. In-flight fetches are
+// served to their waiters until ctx is done.
+// See fetchmgr.CachedCFetcher.Shutdown.
+func (f ContextFetcher) Shutdown(ctx context.Context) error {
+	s, ok := f.fetcher.(fetchmgr.Shutdowner)
+	if !ok {
+		return f.fetcher.Close()
+	}
+	return s.Shutdown(ctx.Done())
+}
+
 // CtxFetch fetches values. You can cancel the task by using ctx.Done()
 func (f ContextFetcher) CtxFetch(
 	ctx context.Context,
 	k interface{},
 ) (interface{}, error) {
-	return f.fetcher.CFetch(ctx.Done(), k)
+	return fetchmgr.AsCtxFetcher{CFetcher: f.fetcher}.Fetch(ctx, k)
+}
+
+// Fetch is the same as CtxFetch. It makes ContextFetcher a
+// fetchmgr.CtxFetcher.
+func (f ContextFetcher) Fetch(
+	ctx context.Context,
+	k interface{},
+) (interface{}, error) {
+	return f.CtxFetch(ctx, k)
 }
 
 // New makes the new ContextFetcher from Fetcher.
@@ -39,6 +81,10 @@ func New(
 	fetcher fetchmgr.Fetcher,
 	ss ...fetchmgr.Setting,
 ) ContextFetcher {
+	if fr, ok := fetcher.(fetchmgr.FetchRevalidator); ok {
+		return CNew(fetchmgr.AsCRevalidator{FetchRevalidator: fr}, ss...)
+	}
+
 	cfetcher := fetchmgr.AsCFetcher{Fetcher: fetcher}
 	return CNew(cfetcher, ss...)
 }
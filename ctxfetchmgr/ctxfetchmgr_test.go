@@ -1,13 +1,12 @@
 package ctxfetchmgr_test
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"testing"
 	"time"
 
-	"golang.org/x/net/context"
-
 	. "github.com/hiratara/fetchmgr/ctxfetchmgr"
 )
 
@@ -41,17 +40,20 @@ func TestCtxFetch(t *testing.T) {
 
 		_, err := fetcher.CtxFetch(ctx, "key")
 		if err != nil {
-			t.Fatalf("Thrown %v, wants nil", err)
+			t.Errorf("Thrown %v, wants nil", err)
+			return
 		}
 
 		_, err = fetcher.CtxFetch(ctx, "key")
 		if err != nil {
-			t.Fatalf("Thrown %v, wants nil (from cache)", err)
+			t.Errorf("Thrown %v, wants nil (from cache)", err)
+			return
 		}
 
 		v, err := fetcher.CtxFetch(ctx, "anotherKey") // !!timeout!!
 		if err == nil {
-			t.Fatalf("Successfully gets %v, wants timeout", v)
+			t.Errorf("Successfully gets %v, wants timeout", v)
+			return
 		}
 	}()
 
@@ -67,16 +69,149 @@ func TestCtxFetch(t *testing.T) {
 		time.Sleep(50 * time.Millisecond)
 		_, err := fetcher.CtxFetch(ctx, "key")
 		if err != nil {
-			t.Fatalf("Thrown %v, wants nil (from cache after timeout)", err)
+			t.Errorf("Thrown %v, wants nil (from cache after timeout)", err)
+			return
 		}
 
 		// Waiting the 1st goroutine to fetch "anotherKey"
 		time.Sleep(50 * time.Millisecond)
 		_, err = fetcher.CtxFetch(ctx, "anotherKey")
 		if err != nil {
-			t.Fatalf("Thrown %v, wants nil (result of another goroutine)", err)
+			t.Errorf("Thrown %v, wants nil (result of another goroutine)", err)
+			return
 		}
 	}()
 
 	wg.Wait()
 }
+
+type ctxKey struct{}
+
+type valueFetcher struct{}
+
+func (valueFetcher) Fetch(ctx context.Context, key interface{}) (interface{}, error) {
+	t := time.NewTimer(50 * time.Millisecond)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return ctx.Value(ctxKey{}), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestCtxNew(t *testing.T) {
+	fetcher := CtxNew(valueFetcher{})
+	defer fetcher.Close()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	ctx1, cancel1 := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel1()
+
+	v, err := fetcher.CtxFetch(ctx1, "key")
+	if err == nil {
+		t.Fatalf("Successfully gets %v, wants timeout", v)
+	}
+
+	// The fetch is still running after the 1st caller's timeout
+	v, err = fetcher.Fetch(context.Background(), "key")
+	if err != nil {
+		t.Fatalf("Thrown %v, wants nil", err)
+	}
+	if v != "value" {
+		t.Fatalf(`Gets %v, wants "value" (from the 1st caller's context)`, v)
+	}
+}
+
+func TestCtxNewClose(t *testing.T) {
+	fetcher := CtxNew(valueFetcher{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		fetcher.Close()
+	}()
+
+	v, err := fetcher.CtxFetch(context.Background(), "key")
+	if err == nil {
+		t.Fatalf("Successfully gets %v, wants an error", v)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	fetcher := CNew(slowFetcher{})
+
+	errs := make(chan error)
+	go func() {
+		_, err := fetcher.CtxFetch(context.Background(), "key")
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // Wait for fetching
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := fetcher.Shutdown(ctx); err != nil {
+		t.Fatalf("Thrown %v, wants nil", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Thrown %v, wants nil (in-flight fetch)", err)
+	}
+}
+
+type valueObserver struct {
+	mutex  sync.Mutex
+	values []interface{}
+}
+
+func (vo *valueObserver) add(ctx context.Context) {
+	vo.mutex.Lock()
+	defer vo.mutex.Unlock()
+
+	vo.values = append(vo.values, ctx.Value(ctxKey{}))
+}
+
+func (vo *valueObserver) FetchStart(ctx context.Context, key interface{}) {
+	vo.add(ctx)
+}
+
+func (vo *valueObserver) FetchEnd(ctx context.Context, key interface{}, d time.Duration, err error) {
+	vo.add(ctx)
+}
+
+func (vo *valueObserver) Hit(ctx context.Context, key interface{}) {
+	vo.add(ctx)
+}
+
+func (vo *valueObserver) SharedWait(ctx context.Context, key interface{}) {}
+
+func (vo *valueObserver) Evict(ctx context.Context, key interface{}) {}
+
+func (vo *valueObserver) Canceled(ctx context.Context, key interface{}) {}
+
+func TestObserver(t *testing.T) {
+	vo := &valueObserver{}
+	fetcher := CNew(slowFetcher{}, SetObserver(vo))
+	defer fetcher.Close()
+
+	ctx1 := context.WithValue(context.Background(), ctxKey{}, "first")
+	if _, err := fetcher.CtxFetch(ctx1, "key"); err != nil {
+		t.Fatalf("Thrown %v, wants nil", err)
+	}
+	ctx2 := context.WithValue(context.Background(), ctxKey{}, "second")
+	if _, err := fetcher.CtxFetch(ctx2, "key"); err != nil {
+		t.Fatalf("Thrown %v, wants nil", err)
+	}
+
+	vo.mutex.Lock()
+	defer vo.mutex.Unlock()
+	wants := []interface{}{"first", "first", "second"}
+	if len(vo.values) != len(wants) {
+		t.Fatalf("Gets %v, wants %v", vo.values, wants)
+	}
+	for i, v := range vo.values {
+		if v != wants[i] {
+			t.Fatalf("Gets %v, wants %v", vo.values, wants)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package fetchmgr
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrFallbackRequested means the predicate of FallbackCFetcher rejected the
+// result of the fetcher
+var ErrFallbackRequested = errors.New("fallback requested")
+
+// FallbackCFetcher tries multiple fetchers in order until one of them
+// succeeds
+type FallbackCFetcher struct {
+	fetchers       []CFetcher
+	shouldFallback func(interface{}, error) bool
+}
+
+// NewFallbackCFetcher creates the instance which falls back on errors
+func NewFallbackCFetcher(fs ...CFetcher) *FallbackCFetcher {
+	return NewFallbackCFetcherWithPredicate(nil, fs...)
+}
+
+// NewFallbackCFetcherWithPredicate creates FallbackCFetcher which decides
+// whether to try the next fetcher by shouldFallback with the result of the
+// current one. It falls back on errors if shouldFallback is nil.
+func NewFallbackCFetcherWithPredicate(
+	shouldFallback func(interface{}, error) bool,
+	fs ...CFetcher,
+) *FallbackCFetcher {
+	if shouldFallback == nil {
+		shouldFallback = func(v interface{}, err error) bool { return err != nil }
+	}
+	return &FallbackCFetcher{fetchers: fs, shouldFallback: shouldFallback}
+}
+
+// CFetch calls internal fetchers in order. It returns InnerErrors which
+// holds errors of all fetchers when every fetcher fails, or ErrNoFetchers
+// if there are no fetchers.
+func (ff *FallbackCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	v, _, err := fallbackCall(ff, cancel, func(f CFetcher) fetchCall {
+		return cfetchCall(f, key)
+	})
+	return v, err
+}
+
+// Revalidate revalidates the old value by internal fetchers in order.
+// Fetchers which aren't Revalidator fetch new values instead.
+func (ff *FallbackCFetcher) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	return fallbackCall(ff, cancel, func(f CFetcher) fetchCall {
+		return revalidateCall(f, key, old)
+	})
+}
+
+// canRevalidate reports whether any of the internal fetchers can revalidate
+// values
+func (ff *FallbackCFetcher) canRevalidate() bool {
+	for _, f := range ff.fetchers {
+		if _, ok := RevalidatorOf(f); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func fallbackCall(
+	ff *FallbackCFetcher,
+	cancel <-chan struct{},
+	call func(CFetcher) fetchCall,
+) (interface{}, bool, error) {
+	if len(ff.fetchers) == 0 {
+		return nil, false, ErrNoFetchers
+	}
+
+	var errs []InnerError
+	for _, f := range ff.fetchers {
+		v, changed, err := call(f)(cancel)
+		if !ff.shouldFallback(v, err) {
+			return v, changed, err
+		}
+
+		if err == nil {
+			err = ErrFallbackRequested
+		}
+		errs = append(errs, InnerError{f, err})
+
+		select {
+		case <-cancel:
+			return nil, false, ErrFetchCanceled
+		default:
+		}
+	}
+
+	return nil, false, InnerErrors(errs)
+}
+
+// Close calls Close() for all internal FetchCloser instances
+func (ff *FallbackCFetcher) Close() error {
+	var errs []InnerError
+	for _, f := range ff.fetchers {
+		fc, ok := f.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := fc.Close(); err != nil {
+			errs = append(errs, InnerError{f, err})
+		}
+	}
+	if len(errs) > 0 {
+		return InnerErrors(errs)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package fetchmgr_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func TestFallbackCFetcher(t *testing.T) {
+	primary := AsCFetcher{mapFetcher{
+		1: {"one", nil},
+		2: {"", errors.New("primary is down")},
+		3: {"", errors.New("primary is down")},
+	}}
+	replica := AsCFetcher{mapFetcher{
+		2: {"two", nil},
+		3: {"", errors.New("replica is down")},
+	}}
+	defaults := AsCFetcher{mapFetcher{
+		3: {"", nil},
+	}}
+	ff := NewFallbackCFetcher(primary, replica, defaults)
+	defer ff.Close()
+
+	one, err := str(ff.CFetch(nil, 1))
+	if err != nil || one != "one" {
+		t.Fatalf(`Gets (%v, %v), wants ("one", nil)`, one, err)
+	}
+
+	two, err := str(ff.CFetch(nil, 2))
+	if err != nil || two != "two" {
+		t.Fatalf(`Gets (%v, %v), wants ("two", nil)`, two, err)
+	}
+
+	// Empty values are rejected only by the predicate
+	if three, err := str(ff.CFetch(nil, 3)); err != nil || three != "" {
+		t.Fatalf(`Gets (%v, %v), wants ("", nil)`, three, err)
+	}
+	ff = NewFallbackCFetcherWithPredicate(func(v interface{}, err error) bool {
+		return err != nil || v == ""
+	}, primary, replica, defaults)
+	v, err := ff.CFetch(nil, 3)
+	ies, ok := err.(InnerErrors)
+	if !ok {
+		t.Fatalf("Gets (%v, %v), wants InnerErrors", v, err)
+	}
+	if len(ies) != 3 {
+		t.Fatalf("Gets %d errors, wants 3", len(ies))
+	}
+	if ies[2].Err != ErrFallbackRequested {
+		t.Fatalf("Gets %v, wants ErrFallbackRequested", ies[2].Err)
+	}
+}
+
+func TestFallbackCFetcherEmpty(t *testing.T) {
+	ff := NewFallbackCFetcher()
+	defer ff.Close()
+
+	if _, err := ff.CFetch(nil, "key"); err != ErrNoFetchers {
+		t.Fatalf("Gets %v, wants ErrNoFetchers", err)
+	}
+}
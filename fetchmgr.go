@@ -3,6 +3,7 @@ package fetchmgr
 import (
 	"errors"
 	"io"
+	"strconv"
 	"time"
 )
 
@@ -53,6 +54,78 @@ func (tf AsFetcher) Fetch(key interface{}) (interface{}, error) {
 	return tf.CFetch(nil, key)
 }
 
+// FetchRevalidator is Revalidator for Fetcher
+type FetchRevalidator interface {
+	Fetcher
+	Revalidate(key interface{}, old interface{}) (value interface{}, changed bool, err error)
+}
+
+// AsCRevalidator makes Revalidator from FetchRevalidator. You will never
+// cancel CFetch and Revalidate calls of this type
+type AsCRevalidator struct {
+	FetchRevalidator
+}
+
+// CFetch fetches values
+func (tr AsCRevalidator) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	return tr.Fetch(key)
+}
+
+// Revalidate revalidates old values
+func (tr AsCRevalidator) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	return tr.FetchRevalidator.Revalidate(key, old)
+}
+
+// asCFetcher makes CFetcher from Fetcher keeping Revalidate method
+func asCFetcher(f Fetcher) CFetcher {
+	fr, ok := f.(FetchRevalidator)
+	if ok {
+		return AsCRevalidator{fr}
+	}
+	return AsCFetcher{f}
+}
+
+// asFetchRevalidator makes FetchRevalidator from Revalidator
+type asFetchRevalidator struct {
+	Revalidator
+}
+
+// Fetch fetches values
+func (fr asFetchRevalidator) Fetch(key interface{}) (interface{}, error) {
+	return fr.CFetch(nil, key)
+}
+
+// Revalidate revalidates old values
+func (fr asFetchRevalidator) Revalidate(key interface{}, old interface{}) (interface{}, bool, error) {
+	return fr.Revalidator.Revalidate(nil, key, old)
+}
+
+// asFetcher makes Fetcher from CFetcher keeping Revalidate method
+func asFetcher(f CFetcher) Fetcher {
+	if r, ok := RevalidatorOf(f); ok {
+		return asFetchRevalidator{r}
+	}
+	return AsFetcher{f}
+}
+
+// asFetchCloser makes FetchCloser from CFetchCloser keeping Revalidate method
+func asFetchCloser(fc CFetchCloser) FetchCloser {
+	if r, ok := RevalidatorOf(fc); ok {
+		return struct {
+			asFetchRevalidator
+			io.Closer
+		}{asFetchRevalidator{r}, fc}
+	}
+	return struct {
+		Fetcher
+		io.Closer
+	}{AsFetcher{fc}, fc}
+}
+
 // FuncFetcher makes new Fetcher from a function
 type FuncFetcher func(interface{}) (interface{}, error)
 
@@ -61,27 +134,39 @@ func (f FuncFetcher) Fetch(k interface{}) (interface{}, error) {
 	return f(k)
 }
 
-// CNew wraps the fetcher and memoizes the results for Fetch
+// CNew wraps the fetcher and memoizes the results for Fetch.
+// The returned value implements Shutdowner as well. It owns the fetcher, and
+// closes it once after closing all buckets. With SetVirtualNodes, it is
+// *CachedRing.
 func CNew(
 	fetcher CFetcher,
 	ss ...Setting,
 ) CFetchCloser {
-	setting := &fetcherSetting{
-		bucketNum: 10,
-		ttl:       1 * time.Minute,
-		interval:  1 * time.Second,
-	}
+	setting := newFetcherSetting()
 
 	for _, set := range ss {
 		set(setting)
 	}
 
+	// All buckets expire keys on the same wheel. The last reference is held
+	// by ownedCFetcher not to stop it while buckets are replaced.
+	wheel := newTimingWheel(setting.interval, int(setting.bucketNum)+1, setting.clock)
 	fs := make([]CFetcher, setting.bucketNum)
 	for i := range fs {
-		fs[i] = NewCachedCFetcher(fetcher, setting.ttl, setting.interval)
+		fs[i] = newSharedCachedCFetcher(fetcher, setting, wheel)
 	}
 
-	return NewBucketedCFetcher(fs)
+	if setting.vnodes > 0 {
+		ring := NewRingCFetcherWithHash(setting.vnodes, setting.hashFunc)
+		for i, f := range fs {
+			ring.Add(strconv.Itoa(i), f, 1)
+		}
+		owned := &ownedCFetcher{buckets: ring, fetcher: fetcher, wheel: wheel}
+		return &CachedRing{owned, ring, setting}
+	}
+
+	buckets := NewBucketedCFetcherWithHash(fs, setting.hashFunc)
+	return &ownedCFetcher{buckets: buckets, fetcher: fetcher, wheel: wheel}
 }
 
 // New wraps the fetcher and memoizes the results for Fetch
@@ -89,7 +174,7 @@ func New(
 	fetcher Fetcher,
 	ss ...Setting,
 ) FetchCloser {
-	cfetcher := AsCFetcher{fetcher}
+	cfetcher := asCFetcher(fetcher)
 	ccfetcher := CNew(cfetcher, ss...)
 	return struct {
 		Fetcher
@@ -99,20 +184,46 @@ func New(
 
 type fetcherSetting struct {
 	ttl       time.Duration
+	staleTTL  time.Duration
 	interval  time.Duration
 	bucketNum uint
+	vnodes    int
+	hashFunc  func(interface{}) uint
+	inline    bool
+	recovers  bool
+	clock     Clock
+	observer  CtxObserver
+}
+
+func newFetcherSetting() *fetcherSetting {
+	return &fetcherSetting{
+		bucketNum: 10,
+		ttl:       1 * time.Minute,
+		interval:  1 * time.Second,
+		hashFunc:  hash,
+		clock:     systemClock{},
+	}
 }
 
 // Setting makes arguments for New constracter
 type Setting func(*fetcherSetting)
 
-// SetTTL sets the expiration time of caches
+// SetTTL sets the expiration time of caches. With 0 or less, values aren't
+// cached, and only concurrent calls for the same key share one fetch.
 func SetTTL(t time.Duration) Setting {
 	return func(cf *fetcherSetting) {
 		cf.ttl = t
 	}
 }
 
+// SetStaleTTL sets how long expired values are kept to revalidate them.
+// It only works for Revalidator. The default value is the same as TTL.
+func SetStaleTTL(t time.Duration) Setting {
+	return func(cf *fetcherSetting) {
+		cf.staleTTL = t
+	}
+}
+
 // SetInterval sets an interval to check expirations
 func SetInterval(t time.Duration) Setting {
 	return func(cf *fetcherSetting) {
@@ -122,11 +233,82 @@ func SetInterval(t time.Duration) Setting {
 
 // SetBucketNum sets the number of map instance
 // The default values is 10.
-// Only Hasher instance, int, float64, string types support multiple map
-// instance feature. If you don't use these types as keys, you had better
-// set 1 for BucketNum.
+// Keys are scattered by their hash values. Hasher instances and basic types
+// are hashed fast, and other comparable types like structs and arrays are
+// hashed by reflection.
 func SetBucketNum(n uint) Setting {
 	return func(cf *fetcherSetting) {
 		cf.bucketNum = n
 	}
 }
+
+// SetVirtualNodes makes buckets into a consistent hash ring with n virtual
+// nodes for each bucket. Buckets are chosen by hash values modulo the number
+// of buckets by default.
+func SetVirtualNodes(n int) Setting {
+	return func(cf *fetcherSetting) {
+		cf.vnodes = n
+	}
+}
+
+// SetHashFunc sets the function to calculate hash values of keys, which
+// decide buckets for keys. Equal keys must have the same hash value.
+// The default function hashes keys with a random seed for each process, and
+// StableHash returns the same values among processes. It panics if f is nil.
+func SetHashFunc(f func(interface{}) uint) Setting {
+	if f == nil {
+		panic("fetchmgr: SetHashFunc is called with nil")
+	}
+	return func(cf *fetcherSetting) {
+		cf.hashFunc = f
+	}
+}
+
+// SetInlineFetch makes the first caller of a missing key fetch it on its own
+// goroutine, and other callers wait for it. It saves a goroutine for each
+// miss. If the first caller cancels, the fetch is canceled and one of the
+// waiters fetches it again, so the fetcher should respect the cancel chan.
+// The cancel chan of CFetch is passed to the fetcher as it is, and Close
+// doesn't interrupt such fetches.
+func SetInlineFetch(inline bool) Setting {
+	return func(cf *fetcherSetting) {
+		cf.inline = inline
+	}
+}
+
+// SetRecoverPanics makes CachedCFetcher recover panics of the fetcher and
+// return PanicError. By default, callers waiting for the key get PanicError,
+// and the panic goes on.
+func SetRecoverPanics(recovers bool) Setting {
+	return func(cf *fetcherSetting) {
+		cf.recovers = recovers
+	}
+}
+
+// SetClock sets the clock to expire caches. Use FakeClock to test expiration
+// without sleeping.
+func SetClock(c Clock) Setting {
+	return func(cf *fetcherSetting) {
+		cf.clock = c
+	}
+}
+
+// SetObserver adds the observer which receives events of caches. Multiple
+// observers are called in order.
+func SetObserver(o Observer) Setting {
+	return func(cf *fetcherSetting) {
+		if o != nil {
+			addObserver(cf, ctxObserver{o})
+		}
+	}
+}
+
+// SetCtxObserver adds the observer which receives events of caches with the
+// caller's context
+func SetCtxObserver(o CtxObserver) Setting {
+	return func(cf *fetcherSetting) {
+		if o != nil {
+			addObserver(cf, o)
+		}
+	}
+}
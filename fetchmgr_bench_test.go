@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"runtime"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -37,6 +38,119 @@ func BenchmarkFetcher(b *testing.B) {
 	})
 }
 
+// BenchmarkManyBuckets creates many caches with many buckets, and reports
+// the number of goroutines used to expire keys
+func BenchmarkManyBuckets(b *testing.B) {
+	for j := 0; j < b.N; j++ {
+		var fs []FetchCloser
+		for i := 0; i < 32; i++ {
+			f := New(
+				constFetcher(0),
+				SetBucketNum(64),
+				SetTTL(time.Minute),
+				SetInterval(time.Millisecond),
+			)
+			fs = append(fs, f)
+		}
+
+		for k := 0; k < keynum; k++ {
+			for _, f := range fs {
+				_, _ = f.Fetch(strconv.Itoa(k))
+			}
+		}
+		b.ReportMetric(float64(runtime.NumGoroutine()), "goroutines")
+
+		for _, f := range fs {
+			f.Close()
+		}
+	}
+}
+
+// BenchmarkHitPath fetches from many goroutines with a 99% hit ratio
+func BenchmarkHitPath(b *testing.B) {
+	cached := New(constFetcher(0), SetTTL(time.Hour))
+	defer cached.Close()
+
+	for k := 0; k < keynum; k++ {
+		_, _ = cached.Fetch(k)
+	}
+
+	var miss int64 = int64(keynum)
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := r.Intn(keynum)
+			if r.Intn(100) == 0 {
+				key = int(atomic.AddInt64(&miss, 1))
+			}
+			_, _ = cached.Fetch(key)
+		}
+	})
+}
+
+// BenchmarkHitOnly fetches cached keys from many goroutines. It measures
+// the hit path alone.
+func BenchmarkHitOnly(b *testing.B) {
+	cached := New(constFetcher(0), SetTTL(time.Hour))
+	defer cached.Close()
+
+	for k := 0; k < keynum; k++ {
+		_, _ = cached.Fetch(k)
+	}
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			_, _ = cached.Fetch(r.Intn(keynum))
+		}
+	})
+}
+
+// BenchmarkColdKeys fetches only missing keys
+func BenchmarkColdKeys(b *testing.B) {
+	for _, inline := range []bool{false, true} {
+		b.Run(fmt.Sprintf("inline=%v", inline), func(b *testing.B) {
+			cached := New(constFetcher(0), SetTTL(time.Hour), SetInlineFetch(inline))
+			defer cached.Close()
+
+			var key int64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, _ = cached.Fetch(int(atomic.AddInt64(&key, 1)))
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkColdKeysCancel fetches only missing keys with a cancel chan
+func BenchmarkColdKeysCancel(b *testing.B) {
+	for _, inline := range []bool{false, true} {
+		b.Run(fmt.Sprintf("inline=%v", inline), func(b *testing.B) {
+			cached := CNew(
+				AsCFetcher{Fetcher: constFetcher(0)},
+				SetTTL(time.Hour),
+				SetInlineFetch(inline),
+			)
+			defer cached.Close()
+
+			cancel := make(chan struct{})
+			var key int64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, _ = cached.CFetch(cancel, int(atomic.AddInt64(&key, 1)))
+				}
+			})
+		})
+	}
+}
+
 func benchmarkFetcher(b *testing.B, wrap func(Fetcher) Fetcher) {
 	b.StopTimer()
 	var baseN = fetchnum / conc
@@ -162,8 +162,8 @@ type testCFetcher struct {
 	cnt uint32
 }
 
+// CFetch waits for cancel. Add the number of calls to cf.wg before calling.
 func (cf *testCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
-	cf.wg.Add(1)
 	select {
 	case <-cancel:
 		atomic.AddUint32(&cf.cnt, 1)
@@ -174,6 +174,7 @@ func (cf *testCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interfa
 
 func TestCancelAndClose(t *testing.T) {
 	cf := &testCFetcher{}
+	cf.wg.Add(2) // "key" and "KEY"
 	ccf := CNew(cf)
 
 	var canceled uint32
@@ -183,7 +184,7 @@ func TestCancelAndClose(t *testing.T) {
 	go func() {
 		_, err := ccf.CFetch(cancel1, "key")
 		if err == nil {
-			t.Fatalf("Gets nil, wants errors")
+			t.Errorf("Gets nil, wants errors")
 		}
 		atomic.AddUint32(&canceled, 1)
 		close(done1)
@@ -193,7 +194,7 @@ func TestCancelAndClose(t *testing.T) {
 	go func() {
 		_, err := ccf.CFetch(nil, "key")
 		if err == nil {
-			t.Fatalf("Gets nil, wants errors")
+			t.Errorf("Gets nil, wants errors")
 		}
 		atomic.AddUint32(&canceled, 1)
 		close(done2)
@@ -203,7 +204,7 @@ func TestCancelAndClose(t *testing.T) {
 	go func() {
 		_, err := ccf.CFetch(nil, "KEY")
 		if err == nil {
-			t.Fatalf("Gets nil, wants errors")
+			t.Errorf("Gets nil, wants errors")
 		}
 		atomic.AddUint32(&canceled, 1)
 		close(done3)
@@ -212,11 +213,11 @@ func TestCancelAndClose(t *testing.T) {
 	close(cancel1)
 	<-done1
 	time.Sleep(10 * time.Millisecond) // Check if done2 isn't canceled
-	if canceled != 1 {
-		t.Fatalf("Gets %d canceled, wants 1", canceled)
+	if n := atomic.LoadUint32(&canceled); n != 1 {
+		t.Fatalf("Gets %d canceled, wants 1", n)
 	}
-	if cf.cnt != 0 {
-		t.Fatalf("Gets %d canceled internal calls, wants 0", canceled)
+	if n := atomic.LoadUint32(&cf.cnt); n != 0 {
+		t.Fatalf("Gets %d canceled internal calls, wants 0", n)
 	}
 
 	ccf.Close()
@@ -224,10 +225,10 @@ func TestCancelAndClose(t *testing.T) {
 	<-done3
 	cf.wg.Wait()
 	time.Sleep(10 * time.Millisecond) // Wait for all cancel calls
-	if canceled != 3 {
-		t.Fatalf("Gets %d canceled, wants 3", canceled)
+	if n := atomic.LoadUint32(&canceled); n != 3 {
+		t.Fatalf("Gets %d canceled, wants 3", n)
 	}
-	if cf.cnt != 2 {
-		t.Fatalf(`Gets %d canceled internal calls, wants 2 ("key" and "KEY")`, cf.cnt)
+	if n := atomic.LoadUint32(&cf.cnt); n != 2 {
+		t.Fatalf(`Gets %d canceled internal calls, wants 2 ("key" and "KEY")`, n)
 	}
 }
@@ -0,0 +1,45 @@
+package fetchmgrtest
+
+import (
+	"testing"
+	"time"
+)
+
+// WaitTimeout is how long WaitCalls and CheckGoroutines wait
+var WaitTimeout = time.Second
+
+// AssertCalls fails the test unless key has been fetched n times
+func AssertCalls(t testing.TB, f *Fetcher, key interface{}, n int) {
+	t.Helper()
+	if c := f.Calls(key); c != n {
+		t.Errorf("%v has been fetched %d times, wants %d", key, c, n)
+	}
+}
+
+// AssertFetchedOnce fails the test unless key has been fetched exactly once
+func AssertFetchedOnce(t testing.TB, f *Fetcher, key interface{}) {
+	t.Helper()
+	AssertCalls(t, f, key, 1)
+}
+
+// AssertClosedOnce fails the test unless f has been closed exactly once
+func AssertClosedOnce(t testing.TB, f *Fetcher) {
+	t.Helper()
+	if c := f.Closes(); c != 1 {
+		t.Errorf("Fetcher has been closed %d times, wants 1", c)
+	}
+}
+
+// WaitCalls waits until key is fetched n times. It's useful to wait for
+// calls blocked by Block. The test fails after WaitTimeout.
+func WaitCalls(t testing.TB, f *Fetcher, key interface{}, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(WaitTimeout)
+	for f.Calls(key) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v has been fetched %d times, wants %d", key, f.Calls(key), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
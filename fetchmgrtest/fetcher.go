@@ -0,0 +1,152 @@
+// Package fetchmgrtest provides a fake fetcher and assertions to test code
+// which uses fetchmgr.
+package fetchmgrtest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// ErrNoResult means no results are scripted for the key
+var ErrNoResult = errors.New("no results for the key")
+
+// Result is a scripted result of Fetcher
+type Result struct {
+	Value interface{}
+	Err   error
+}
+
+// Value makes Result which returns v
+func Value(v interface{}) Result {
+	return Result{Value: v}
+}
+
+// Error makes Result which returns err
+func Error(err error) Result {
+	return Result{Err: err}
+}
+
+// Fetcher is a programmable fake fetchmgr.CFetcher. It returns scripted
+// results, counts calls and can block calls until they are released.
+type Fetcher struct {
+	mutex    sync.Mutex
+	results  map[interface{}][]Result
+	gates    map[interface{}]chan struct{}
+	calls    map[interface{}]int
+	canceled map[interface{}]int
+	closes   int
+}
+
+// NewFetcher creates Fetcher. It returns ErrNoResult until results are
+// scripted by Return.
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		results:  make(map[interface{}][]Result),
+		gates:    make(map[interface{}]chan struct{}),
+		calls:    make(map[interface{}]int),
+		canceled: make(map[interface{}]int),
+	}
+}
+
+// Return scripts results for key. Each call returns the next result, and the
+// last one is repeated.
+func (f *Fetcher) Return(key interface{}, rs ...Result) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.results[key] = append(f.results[key], rs...)
+}
+
+// Block makes calls for key wait until Release is called or they are
+// canceled. Canceled calls return fetchmgr.ErrFetchCanceled.
+func (f *Fetcher) Block(key interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.gates[key]; !ok {
+		f.gates[key] = make(chan struct{})
+	}
+}
+
+// Release resumes calls blocked by Block
+func (f *Fetcher) Release(key interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if gate, ok := f.gates[key]; ok {
+		close(gate)
+		delete(f.gates, key)
+	}
+}
+
+// CFetch returns the next result for key. Blocked calls take the result
+// when they are released, and canceled calls don't take any results.
+func (f *Fetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	f.mutex.Lock()
+	f.calls[key]++
+	gate := f.gates[key]
+	f.mutex.Unlock()
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-cancel:
+			f.mutex.Lock()
+			f.canceled[key]++
+			f.mutex.Unlock()
+			return nil, fetchmgr.ErrFetchCanceled
+		}
+	}
+
+	f.mutex.Lock()
+	r := nextResult(f, key)
+	f.mutex.Unlock()
+
+	return r.Value, r.Err
+}
+
+func nextResult(f *Fetcher, key interface{}) Result {
+	rs := f.results[key]
+	if len(rs) == 0 {
+		return Error(ErrNoResult)
+	}
+	if len(rs) > 1 {
+		f.results[key] = rs[1:]
+	}
+	return rs[0]
+}
+
+// Close counts calls of Close
+func (f *Fetcher) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.closes++
+	return nil
+}
+
+// Calls returns the number of calls for key
+func (f *Fetcher) Calls(key interface{}) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.calls[key]
+}
+
+// Canceled returns the number of blocked calls for key which were canceled
+func (f *Fetcher) Canceled(key interface{}) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.canceled[key]
+}
+
+// Closes returns the number of calls of Close
+func (f *Fetcher) Closes() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.closes
+}
@@ -0,0 +1,151 @@
+package fetchmgrtest_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+	. "github.com/hiratara/fetchmgr/fetchmgrtest"
+)
+
+func TestFetcher(t *testing.T) {
+	errFetch := errors.New("fetch error")
+	f := NewFetcher()
+	f.Return("key", Error(errFetch), Value("v1"), Value("v2"))
+
+	if _, err := f.CFetch(nil, "key"); err != errFetch {
+		t.Fatalf("Gets %v, wants errFetch", err)
+	}
+	for _, wants := range []string{"v1", "v2", "v2"} {
+		v, err := f.CFetch(nil, "key")
+		if err != nil || v != wants {
+			t.Fatalf("Gets (%v, %v), wants (%s, nil)", v, err, wants)
+		}
+	}
+	if _, err := f.CFetch(nil, "unknown"); err != ErrNoResult {
+		t.Fatalf("Gets %v, wants ErrNoResult", err)
+	}
+
+	AssertCalls(t, f, "key", 4)
+	AssertFetchedOnce(t, f, "unknown")
+}
+
+func TestBlock(t *testing.T) {
+	f := NewFetcher()
+	f.Return("key", Value("value"))
+	f.Block("key")
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if _, err := f.CFetch(cancel, "key"); err != fetchmgr.ErrFetchCanceled {
+		t.Fatalf("Gets %v, wants ErrFetchCanceled", err)
+	}
+	if n := f.Canceled("key"); n != 1 {
+		t.Fatalf("Gets %d canceled calls, wants 1", n)
+	}
+
+	done := make(chan interface{})
+	go func() {
+		v, _ := f.CFetch(nil, "key")
+		done <- v
+	}()
+	WaitCalls(t, f, "key", 2)
+
+	f.Release("key")
+	if v := <-done; v != "value" {
+		t.Fatalf(`Gets %v, wants "value"`, v)
+	}
+}
+
+func TestBlockResults(t *testing.T) {
+	f := NewFetcher()
+	f.Return("key", Value("v1"))
+	f.Block("key")
+
+	// Canceled calls don't consume results
+	cancel := make(chan struct{})
+	close(cancel)
+	f.CFetch(cancel, "key")
+
+	done := make(chan interface{})
+	go func() {
+		v, _ := f.CFetch(nil, "key")
+		done <- v
+	}()
+	WaitCalls(t, f, "key", 2)
+
+	// Results scripted while blocking are used
+	f.Return("key", Value("v2"))
+	f.Release("key")
+	if v := <-done; v != "v1" {
+		t.Fatalf(`Gets %v, wants "v1"`, v)
+	}
+	if v, _ := f.CFetch(nil, "key"); v != "v2" {
+		t.Fatalf(`Gets %v, wants "v2"`, v)
+	}
+}
+
+func TestWithCache(t *testing.T) {
+	check := CheckGoroutines(t)
+
+	f := NewFetcher()
+	f.Return("key", Value("value"))
+	f.Block("key")
+	cached := fetchmgr.CNew(f)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cached.CFetch(nil, "key")
+			if err != nil || v != "value" {
+				t.Errorf(`Gets (%v, %v), wants ("value", nil)`, v, err)
+			}
+		}()
+	}
+	WaitCalls(t, f, "key", 1)
+	f.Release("key")
+	wg.Wait()
+
+	AssertFetchedOnce(t, f, "key")
+
+	cached.Close()
+	cached.Close()
+	AssertClosedOnce(t, f)
+	check()
+}
+
+type recordingTB struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.failed = true
+}
+
+func TestCheckGoroutines(t *testing.T) {
+	defer func(d time.Duration) { WaitTimeout = d }(WaitTimeout)
+	WaitTimeout = 10 * time.Millisecond
+
+	r := &recordingTB{TB: t}
+	check := CheckGoroutines(r)
+	stop := make(chan struct{})
+	go func() { <-stop }()
+	check()
+	if !r.failed {
+		t.Fatalf("Gets no failures, wants a leak")
+	}
+
+	close(stop)
+	r.failed = false
+	CheckGoroutines(r)()
+	if r.failed {
+		t.Fatalf("Gets a leak, wants no failures")
+	}
+}
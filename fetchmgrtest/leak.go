@@ -0,0 +1,69 @@
+package fetchmgrtest
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CheckGoroutines takes a snapshot of running goroutines. The returned
+// function fails the test if goroutines started after the snapshot are still
+// running. Call it after closing fetchers to find leaks:
+//
+//	check := fetchmgrtest.CheckGoroutines(t)
+//	f := fetchmgr.CNew(fetcher)
+//	...
+//	f.Close()
+//	check()
+func CheckGoroutines(t testing.TB) func() {
+	before := goroutines()
+
+	return func() {
+		t.Helper()
+
+		var leaked []string
+		deadline := time.Now().Add(WaitTimeout)
+		for {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if len(leaked) > 0 {
+			t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	}
+}
+
+// goroutines returns stacks of all goroutines by their IDs
+func goroutines() map[string]string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+	for _, s := range bytes.Split(buf, []byte("\n\n")) {
+		// The header looks like "goroutine 1 [running]:"
+		fields := strings.Fields(string(s))
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		stacks[fields[1]] = string(s)
+	}
+	return stacks
+}
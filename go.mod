@@ -0,0 +1,3 @@
+module github.com/hiratara/fetchmgr
+
+go 1.21
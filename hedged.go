@@ -0,0 +1,223 @@
+package fetchmgr
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencySamples is the number of recent latencies used to calculate
+// percentiles
+const latencySamples = 100
+
+// HedgedCFetcher sends a second request to the internal CFetcher when the
+// first one hasn't returned within a delay, and uses whichever finishes first.
+// The loser is canceled through its cancel chan.
+type HedgedCFetcher struct {
+	wrapped
+	delay      time.Duration
+	percentile float64
+	ratio      float64
+	mutex      sync.Mutex
+	budget     float64
+	latencies  []time.Duration // In order of records
+	sorted     []time.Duration // The same latencies in ascending order
+	next       int
+}
+
+// NewHedgedCFetcher creates HedgedCFetcher which hedges requests after the
+// fixed delay. ratio limits the extra load; e.g. 0.1 means hedged requests
+// are at most 10% of all requests.
+func NewHedgedCFetcher(
+	fetcher CFetcher,
+	delay time.Duration,
+	ratio float64,
+) *HedgedCFetcher {
+	return &HedgedCFetcher{
+		wrapped: wrapped{fetcher},
+		delay:   delay,
+		ratio:   ratio,
+	}
+}
+
+// NewPercentileHedgedCFetcher creates HedgedCFetcher which hedges requests
+// after the p-th percentile (0 < p <= 1) of recent latencies. delay is used
+// until enough latencies are recorded. ratio limits the extra load as
+// NewHedgedCFetcher does. It panics if p is out of the range.
+func NewPercentileHedgedCFetcher(
+	fetcher CFetcher,
+	p float64,
+	delay time.Duration,
+	ratio float64,
+) *HedgedCFetcher {
+	if !(p > 0 && p <= 1) {
+		panic(fmt.Sprintf("fetchmgr: percentile %v is out of (0, 1]", p))
+	}
+	return &HedgedCFetcher{
+		wrapped:    wrapped{fetcher},
+		delay:      delay,
+		percentile: p,
+		ratio:      ratio,
+		latencies:  make([]time.Duration, 0, latencySamples),
+		sorted:     make([]time.Duration, 0, latencySamples),
+	}
+}
+
+type hedgedResult struct {
+	value   interface{}
+	changed bool
+	err     error
+	latency time.Duration
+	primary bool
+}
+
+// CFetch calls the internal CFetcher, and calls it again if it's too slow
+func (hf *HedgedCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	v, _, err := hedgeCall(hf, cancel, cfetchCall(hf.fetcher, key))
+	return v, err
+}
+
+// Revalidate revalidates the old value by the internal CFetcher, and hedges
+// it as CFetch does
+func (hf *HedgedCFetcher) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	return hedgeCall(hf, cancel, revalidateCall(hf.fetcher, key, old))
+}
+
+func hedgeCall(hf *HedgedCFetcher, cancel <-chan struct{}, call fetchCall) (interface{}, bool, error) {
+	results := make(chan hedgedResult, 2)
+	var cancels []chan struct{}
+	defer func() {
+		for _, c := range cancels {
+			close(c)
+		}
+	}()
+
+	attempt := func() {
+		c := make(chan struct{})
+		cancels = append(cancels, c)
+		primary := len(cancels) == 1
+		go func() {
+			start := time.Now()
+			v, changed, err := call(c)
+			results <- hedgedResult{v, changed, err, time.Since(start), primary}
+		}()
+	}
+
+	earnHedgeBudget(hf)
+	start := time.Now()
+	attempt()
+	t := time.NewTimer(hedgeDelay(hf))
+	defer t.Stop()
+
+	var r hedgedResult
+	select {
+	case r = <-results:
+		recordLatency(hf, r.latency)
+		return r.value, r.changed, r.err
+	case <-cancel:
+		return nil, false, ErrFetchCanceled
+	case <-t.C:
+	}
+
+	if spendHedgeBudget(hf) {
+		attempt()
+	}
+
+	for i := 0; i < len(cancels); i++ {
+		select {
+		case r = <-results:
+		case <-cancel:
+			// The primary has taken at least this long
+			recordLatency(hf, time.Since(start))
+			return nil, false, ErrFetchCanceled
+		}
+		if r.err == nil {
+			break
+		}
+	}
+	if r.primary {
+		recordLatency(hf, r.latency)
+	} else {
+		// The primary is canceled, but has taken at least this long
+		recordLatency(hf, time.Since(start))
+	}
+
+	return r.value, r.changed, r.err
+}
+
+// Close closes the internal CFetcher if it is an io.Closer
+func (hf *HedgedCFetcher) Close() error {
+	fc, ok := hf.fetcher.(io.Closer)
+	if ok {
+		return fc.Close()
+	}
+
+	return nil
+}
+
+func hedgeDelay(hf *HedgedCFetcher) time.Duration {
+	hf.mutex.Lock()
+	defer hf.mutex.Unlock()
+
+	if hf.percentile <= 0 || len(hf.latencies) < cap(hf.latencies) {
+		return hf.delay
+	}
+
+	return hf.sorted[int(hf.percentile*float64(len(hf.sorted)-1))]
+}
+
+// recordLatency records d keeping hf.sorted in order, so that hedgeDelay
+// doesn't sort latencies on each call
+func recordLatency(hf *HedgedCFetcher, d time.Duration) {
+	if hf.percentile <= 0 {
+		return // Lock nothing
+	}
+
+	hf.mutex.Lock()
+	defer hf.mutex.Unlock()
+
+	if len(hf.latencies) < cap(hf.latencies) {
+		hf.latencies = append(hf.latencies, d)
+	} else {
+		old := hf.latencies[hf.next]
+		hf.latencies[hf.next] = d
+		hf.next = (hf.next + 1) % len(hf.latencies)
+
+		i := sort.Search(len(hf.sorted), func(i int) bool { return hf.sorted[i] >= old })
+		hf.sorted = append(hf.sorted[:i], hf.sorted[i+1:]...)
+	}
+
+	i := sort.Search(len(hf.sorted), func(i int) bool { return hf.sorted[i] >= d })
+	hf.sorted = append(hf.sorted, 0)
+	copy(hf.sorted[i+1:], hf.sorted[i:])
+	hf.sorted[i] = d
+}
+
+// earnHedgeBudget adds the budget for hedged requests on each request
+func earnHedgeBudget(hf *HedgedCFetcher) {
+	hf.mutex.Lock()
+	defer hf.mutex.Unlock()
+
+	hf.budget += hf.ratio
+	if limit := 1 + hf.ratio*latencySamples; hf.budget > limit {
+		hf.budget = limit
+	}
+}
+
+// spendHedgeBudget reports whether we can send a hedged request
+func spendHedgeBudget(hf *HedgedCFetcher) bool {
+	hf.mutex.Lock()
+	defer hf.mutex.Unlock()
+
+	if hf.budget < 1 {
+		return false
+	}
+	hf.budget--
+	return true
+}
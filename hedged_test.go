@@ -0,0 +1,176 @@
+package fetchmgr_test
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+// firstSlowCFetcher hangs at the first call until it's canceled
+type firstSlowCFetcher struct {
+	calls    int32
+	canceled int32
+}
+
+func (f *firstSlowCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	if atomic.AddInt32(&f.calls, 1) == 1 {
+		<-cancel
+		atomic.AddInt32(&f.canceled, 1)
+		return nil, ErrFetchCanceled
+	}
+	return key, nil
+}
+
+func TestHedgedCFetcher(t *testing.T) {
+	f := &firstSlowCFetcher{}
+	hf := NewHedgedCFetcher(f, 10*time.Millisecond, 1)
+	defer hf.Close()
+
+	v, err := hf.CFetch(nil, "key")
+	if err != nil || v != "key" {
+		t.Fatalf(`Gets (%v, %v), wants ("key", nil)`, v, err)
+	}
+
+	time.Sleep(10 * time.Millisecond) // Wait for the loser
+	if n := atomic.LoadInt32(&f.calls); n != 2 {
+		t.Fatalf("Gets %d calls, wants 2", n)
+	}
+	if n := atomic.LoadInt32(&f.canceled); n != 1 {
+		t.Fatalf("Gets %d canceled calls, wants 1", n)
+	}
+}
+
+func TestHedgedCFetcherBudget(t *testing.T) {
+	f := &firstSlowCFetcher{}
+	hf := NewHedgedCFetcher(f, 10*time.Millisecond, 0)
+	defer hf.Close()
+
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		close(cancel)
+	}()
+
+	v, err := hf.CFetch(cancel, "key")
+	if err != ErrFetchCanceled {
+		t.Fatalf("Gets (%v, %v), wants ErrFetchCanceled", v, err)
+	}
+	if n := atomic.LoadInt32(&f.calls); n != 1 {
+		t.Fatalf("Gets %d calls, wants 1 (no budgets for hedging)", n)
+	}
+}
+
+func TestPercentileHedgedCFetcher(t *testing.T) {
+	var cnt countCFetcher
+	hf := NewPercentileHedgedCFetcher(&cnt, 0.9, time.Millisecond, 0.1)
+	defer hf.Close()
+
+	for i := 0; i < 200; i++ {
+		v, err := hf.CFetch(nil, i)
+		if err != nil || v != i {
+			t.Fatalf("Gets (%v, %v), wants (%d, nil)", v, err, i)
+		}
+	}
+}
+
+// warmCFetcher takes latency while warming up. After that, the first call
+// hangs until it's canceled and the hedged call records its time.
+type warmCFetcher struct {
+	latency time.Duration
+	warm    int32
+	calls   int32
+	hedged  chan time.Time
+}
+
+func (f *warmCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	if atomic.LoadInt32(&f.warm) == 0 {
+		time.Sleep(f.latency)
+		return key, nil
+	}
+
+	if atomic.AddInt32(&f.calls, 1) == 1 {
+		<-cancel
+		return nil, ErrFetchCanceled
+	}
+	f.hedged <- time.Now()
+	return key, nil
+}
+
+func TestPercentileHedgedCFetcherDelay(t *testing.T) {
+	f := &warmCFetcher{latency: 30 * time.Millisecond, hedged: make(chan time.Time, 1)}
+	hf := NewPercentileHedgedCFetcher(f, 0.5, 5*time.Second, 1)
+	defer hf.Close()
+
+	// Record latencies of 30ms or longer
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hf.CFetch(nil, i)
+		}(i)
+	}
+	wg.Wait()
+
+	atomic.StoreInt32(&f.warm, 1)
+	start := time.Now()
+	v, err := hf.CFetch(nil, "key")
+	if err != nil || v != "key" {
+		t.Fatalf(`Gets (%v, %v), wants ("key", nil)`, v, err)
+	}
+
+	// Hedged after the median of latencies, not after the initial delay
+	if d := (<-f.hedged).Sub(start); d < 30*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("Gets the hedged call after %v, wants it after about 30ms", d)
+	}
+}
+
+// oddHangCFetcher hangs on odd calls until they're canceled
+type oddHangCFetcher struct {
+	calls int32
+}
+
+func (f *oddHangCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	if atomic.AddInt32(&f.calls, 1)%2 == 1 {
+		<-cancel
+		return nil, ErrFetchCanceled
+	}
+	return key, nil
+}
+
+func TestPercentileHedgedCFetcherCanceledPrimary(t *testing.T) {
+	delay := 5 * time.Millisecond
+	hf := NewPercentileHedgedCFetcher(&oddHangCFetcher{}, 0.5, delay, 1)
+	defer hf.Close()
+
+	// Hedged calls win every time, and canceled primaries are recorded
+	for i := 0; i <= 100; i++ {
+		start := time.Now()
+		if _, err := hf.CFetch(nil, i); err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+		if d := time.Since(start); d < delay {
+			t.Fatalf("Gets the result after %v, wants it after %v or longer", d, delay)
+		}
+	}
+}
+
+func TestPercentileHedgedCFetcherRange(t *testing.T) {
+	for _, p := range []float64{0, -0.5, 1.5, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Gets no panic for %v, wants a panic", p)
+				}
+			}()
+			NewPercentileHedgedCFetcher(new(countCFetcher), p, time.Millisecond, 0.1)
+		}()
+	}
+
+	// 1 is the slowest latency
+	NewPercentileHedgedCFetcher(new(countCFetcher), 1, time.Millisecond, 0.1)
+}
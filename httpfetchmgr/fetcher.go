@@ -0,0 +1,239 @@
+package httpfetchmgr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// Response is the value which HTTPFetcher returns. It implements
+// fetchmgr.Expirer, so it expires according to Cache-Control and Expires
+// headers.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	expires    time.Time
+}
+
+// Expires returns the expiration time of the response
+func (r *Response) Expires() time.Time {
+	return r.expires
+}
+
+// StatusError means the server returned a status code other than 2xx
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (se StatusError) Error() string {
+	return fmt.Sprintf("unexpected status: %s", se.Status)
+}
+
+// RequestFunc makes a request for the key
+type RequestFunc func(interface{}) (*http.Request, error)
+
+// URLRequest is the default RequestFunc. It uses the key as the URL.
+func URLRequest(key interface{}) (*http.Request, error) {
+	u, ok := key.(string)
+	if !ok {
+		return nil, fmt.Errorf("key is not a URL: %v", key)
+	}
+	return http.NewRequest(http.MethodGet, u, nil)
+}
+
+// HTTPFetcher fetches resources over HTTP. Use it with fetchmgr.CNew, and
+// the cache revalidates expired responses with If-None-Match and
+// If-Modified-Since.
+type HTTPFetcher struct {
+	client  *http.Client
+	request RequestFunc
+	now     func() time.Time
+}
+
+type fetcherSetting struct {
+	client  *http.Client
+	request RequestFunc
+	now     func() time.Time
+}
+
+// FetcherSetting makes arguments for NewHTTPFetcher constracter
+type FetcherSetting func(*fetcherSetting)
+
+// SetClient sets the client to send requests
+func SetClient(c *http.Client) FetcherSetting {
+	return func(fs *fetcherSetting) {
+		fs.client = c
+	}
+}
+
+// SetRequestFunc sets the function to make requests from keys
+func SetRequestFunc(f RequestFunc) FetcherSetting {
+	return func(fs *fetcherSetting) {
+		fs.request = f
+	}
+}
+
+// SetClock sets the clock to calculate expiration times of responses. Pass
+// the same clock as fetchmgr.SetClock of the cache.
+func SetClock(c fetchmgr.Clock) FetcherSetting {
+	return func(fs *fetcherSetting) {
+		fs.now = c.Now
+	}
+}
+
+// NewHTTPFetcher creates HTTPFetcher
+func NewHTTPFetcher(ss ...FetcherSetting) *HTTPFetcher {
+	setting := &fetcherSetting{
+		client:  http.DefaultClient,
+		request: URLRequest,
+		now:     time.Now,
+	}
+
+	for _, set := range ss {
+		set(setting)
+	}
+
+	return &HTTPFetcher{
+		client:  setting.client,
+		request: setting.request,
+		now:     setting.now,
+	}
+}
+
+// CFetch sends the request for the key
+func (hf *HTTPFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	return send(hf, cancel, key, nil)
+}
+
+// Revalidate sends the conditional request for the key. It reuses the old
+// body when the server returns 304 Not Modified.
+func (hf *HTTPFetcher) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	prev, ok := old.(*Response)
+	if !ok {
+		v, err := send(hf, cancel, key, nil)
+		return v, true, err
+	}
+
+	res, err := send(hf, cancel, key, prev)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, res.StatusCode != http.StatusNotModified, nil
+}
+
+func send(
+	hf *HTTPFetcher,
+	cancel <-chan struct{},
+	key interface{},
+	prev *Response,
+) (*Response, error) {
+	req, err := hf.request(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(req.Context())
+	defer stop()
+	if cancel != nil {
+		go func() {
+			select {
+			case <-cancel:
+				stop()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	req = req.WithContext(ctx)
+
+	if prev != nil {
+		if etag := prev.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lm := prev.Header.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+
+	res, err := hf.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	now := hf.now()
+	if prev != nil && res.StatusCode == http.StatusNotModified {
+		header := prev.Header.Clone()
+		for k, v := range res.Header {
+			header[k] = v
+		}
+		return &Response{
+			StatusCode: http.StatusNotModified,
+			Header:     header,
+			Body:       prev.Body,
+			expires:    expiresAt(header, now),
+		}, nil
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, StatusError{res.StatusCode, res.Status}
+	}
+
+	return &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+		expires:    expiresAt(res.Header, now),
+	}, nil
+}
+
+// expiresAt calculates the expiration time from Cache-Control and Expires.
+// Expires is taken relative to Date so that the result follows now of the
+// local clock. It returns the zero time if the response has no information
+// about it.
+func expiresAt(h http.Header, now time.Time) time.Time {
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch {
+		case d == "no-store" || d == "no-cache":
+			return now
+		case strings.HasPrefix(d, "max-age="):
+			sec, err := strconv.Atoi(strings.TrimPrefix(d, "max-age="))
+			if err != nil {
+				continue
+			}
+			age, _ := strconv.Atoi(h.Get("Age"))
+			return now.Add(time.Duration(sec-age) * time.Second)
+		}
+	}
+
+	if e := h.Get("Expires"); e != "" {
+		t, err := http.ParseTime(e)
+		if err != nil {
+			return now // Invalid Expires means "already expired"
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			return t
+		}
+		return now.Add(t.Sub(date))
+	}
+
+	return time.Time{}
+}
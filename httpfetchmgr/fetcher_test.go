@@ -0,0 +1,142 @@
+package httpfetchmgr_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+	. "github.com/hiratara/fetchmgr/httpfetchmgr"
+)
+
+type origin struct {
+	requests    int32
+	conditional int32
+	maxAge      string
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&o.requests, 1)
+	w.Header().Set("Cache-Control", "max-age="+o.maxAge)
+	w.Header().Set("ETag", `"v1"`)
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		atomic.AddInt32(&o.conditional, 1)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write([]byte("hello"))
+}
+
+func TestHTTPFetcherMaxAge(t *testing.T) {
+	o := &origin{maxAge: "60"}
+	s := httptest.NewServer(o)
+	defer s.Close()
+
+	cached := fetchmgr.CNew(NewHTTPFetcher(), fetchmgr.SetTTL(time.Millisecond))
+	defer cached.Close()
+
+	for i := 0; i < 2; i++ {
+		v, err := cached.CFetch(nil, s.URL)
+		if err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+		if body := string(v.(*Response).Body); body != "hello" {
+			t.Fatalf(`Gets %q, wants "hello"`, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&o.requests); n != 1 {
+		t.Fatalf("Gets %d requests, wants 1 (max-age is longer than TTL)", n)
+	}
+}
+
+func TestHTTPFetcherRevalidate(t *testing.T) {
+	o := &origin{maxAge: "0"}
+	s := httptest.NewServer(o)
+	defer s.Close()
+
+	cached := fetchmgr.CNew(
+		NewHTTPFetcher(),
+		fetchmgr.SetTTL(time.Minute),
+		fetchmgr.SetInterval(time.Millisecond),
+	)
+	defer cached.Close()
+
+	first, err := cached.CFetch(nil, s.URL)
+	if err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+
+	time.Sleep(10 * time.Millisecond) // Wait for expiration
+	second, err := cached.CFetch(nil, s.URL)
+	if err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+
+	if n := atomic.LoadInt32(&o.conditional); n != 1 {
+		t.Fatalf("Gets %d conditional requests, wants 1", n)
+	}
+	if first != second {
+		t.Fatalf("Gets %v, wants the old response %v", second, first)
+	}
+}
+
+func TestHTTPFetcherClock(t *testing.T) {
+	o := &origin{maxAge: "60"}
+	s := httptest.NewServer(o)
+	defer s.Close()
+
+	clk := fetchmgr.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	cached := fetchmgr.CNew(
+		NewHTTPFetcher(SetClock(clk)),
+		fetchmgr.SetClock(clk),
+		fetchmgr.SetTTL(time.Hour),
+	)
+	defer cached.Close()
+
+	for _, d := range []time.Duration{0, 59 * time.Second, 2 * time.Second} {
+		clk.Advance(d)
+		if _, err := cached.CFetch(nil, s.URL); err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&o.requests); n != 2 {
+		t.Fatalf("Gets %d requests, wants 2 (expired by max-age on the clock)", n)
+	}
+	if n := atomic.LoadInt32(&o.conditional); n != 1 {
+		t.Fatalf("Gets %d conditional requests, wants 1", n)
+	}
+}
+
+func TestHTTPFetcherExpires(t *testing.T) {
+	date := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", date.Format(http.TimeFormat))
+		w.Header().Set("Expires", date.Add(time.Minute).Format(http.TimeFormat))
+	}))
+	defer s.Close()
+
+	now := time.Now()
+	v, err := NewHTTPFetcher(SetClock(fetchmgr.NewFakeClock(now))).CFetch(nil, s.URL)
+	if err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+	if e := v.(*Response).Expires(); !e.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Gets %v, wants a minute after %v", e, now)
+	}
+}
+
+func TestHTTPFetcherStatusError(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	defer s.Close()
+
+	_, err := NewHTTPFetcher().CFetch(nil, s.URL)
+	se, ok := err.(StatusError)
+	if !ok || se.StatusCode != http.StatusNotFound {
+		t.Fatalf("Gets %v, wants StatusError with 404", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package httpfetchmgr connects fetchmgr with HTTP
+package httpfetchmgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// KeyDecoder makes a key from the path of the request
+type KeyDecoder func(string) (interface{}, error)
+
+// ValueEncoder writes a fetched value into the response
+type ValueEncoder func(http.ResponseWriter, interface{}) error
+
+// StringKey is the default KeyDecoder. It uses the path as the key.
+func StringKey(path string) (interface{}, error) {
+	return path, nil
+}
+
+// JSONValue is the default ValueEncoder. It writes values as JSON.
+func JSONValue(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Handler serves values of the fetcher for GET /{key}
+type Handler struct {
+	fetcher    fetchmgr.CFetcher
+	decodeKey  KeyDecoder
+	writeValue ValueEncoder
+	logger     *slog.Logger
+}
+
+type handlerSetting struct {
+	decodeKey  KeyDecoder
+	writeValue ValueEncoder
+	logger     *slog.Logger
+}
+
+// HandlerSetting makes arguments for NewHandler constracter
+type HandlerSetting func(*handlerSetting)
+
+// SetKeyDecoder sets the function to make keys from paths
+func SetKeyDecoder(d KeyDecoder) HandlerSetting {
+	return func(hs *handlerSetting) {
+		hs.decodeKey = d
+	}
+}
+
+// SetValueEncoder sets the function to write values
+func SetValueEncoder(e ValueEncoder) HandlerSetting {
+	return func(hs *handlerSetting) {
+		hs.writeValue = e
+	}
+}
+
+// SetLogger sets the logger for errors of fetching and encoding. They aren't
+// sent to clients. slog.Default() is used by default.
+func SetLogger(l *slog.Logger) HandlerSetting {
+	return func(hs *handlerSetting) {
+		hs.logger = l
+	}
+}
+
+// NewHandler creates Handler. Use http.StripPrefix to serve it under a
+// sub-path.
+func NewHandler(fetcher fetchmgr.CFetcher, ss ...HandlerSetting) *Handler {
+	setting := &handlerSetting{
+		decodeKey:  StringKey,
+		writeValue: JSONValue,
+	}
+
+	for _, set := range ss {
+		set(setting)
+	}
+	if setting.logger == nil {
+		setting.logger = slog.Default()
+	}
+
+	return &Handler{
+		fetcher:    fetcher,
+		decodeKey:  setting.decodeKey,
+		writeValue: setting.writeValue,
+		logger:     setting.logger,
+	}
+}
+
+// ServeHTTP fetches the value for the key in the path. Fetching is canceled
+// when the request is canceled.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, err := h.decodeKey(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v, err := fetchmgr.AsCtxFetcher{CFetcher: h.fetcher}.Fetch(r.Context(), key)
+	if err != nil {
+		serveError(h, w, r, "fetch failed", key, err, statusCode(err))
+		return
+	}
+
+	// Encode the value before sending anything, so that encoding errors
+	// don't break the response
+	br := &bufferedResponse{header: make(http.Header)}
+	if err := h.writeValue(br, v); err != nil {
+		serveError(h, w, r, "encode failed", key, err, http.StatusInternalServerError)
+		return
+	}
+	flushResponse(br, w)
+}
+
+// serveError logs err and sends only the status text, because err may
+// contain details of backends
+func serveError(
+	h *Handler,
+	w http.ResponseWriter,
+	r *http.Request,
+	msg string,
+	key interface{},
+	err error,
+	code int,
+) {
+	h.logger.ErrorContext(r.Context(), msg, "key", key, "status", code, "err", err)
+	http.Error(w, http.StatusText(code), code)
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, fetchmgr.ErrFetchCanceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, fetchmgr.ErrFetcherClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, fetchmgr.ErrRateLimited):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadGateway
+}
+
+// bufferedResponse is http.ResponseWriter which keeps the whole response
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (br *bufferedResponse) Header() http.Header {
+	return br.header
+}
+
+func (br *bufferedResponse) Write(b []byte) (int, error) {
+	return br.body.Write(b)
+}
+
+func (br *bufferedResponse) WriteHeader(status int) {
+	if br.status == 0 {
+		br.status = status
+	}
+}
+
+func flushResponse(br *bufferedResponse, w http.ResponseWriter) {
+	for k, vs := range br.header {
+		w.Header()[k] = vs
+	}
+	if br.status != 0 {
+		w.WriteHeader(br.status)
+	}
+	w.Write(br.body.Bytes())
+}
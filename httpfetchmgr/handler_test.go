@@ -0,0 +1,148 @@
+package httpfetchmgr_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+	. "github.com/hiratara/fetchmgr/httpfetchmgr"
+)
+
+type squareFetcher struct{}
+
+func (squareFetcher) Fetch(key interface{}) (interface{}, error) {
+	n := key.(int)
+	if n < 0 {
+		return nil, errors.New("negative number")
+	}
+	return map[string]int{"square": n * n}, nil
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	b, _ := io.ReadAll(rec.Body)
+	return rec.Code, strings.TrimSpace(string(b))
+}
+
+func TestHandler(t *testing.T) {
+	cached := fetchmgr.CNew(fetchmgr.AsCFetcher{Fetcher: squareFetcher{}})
+
+	h := NewHandler(cached, SetKeyDecoder(func(path string) (interface{}, error) {
+		return strconv.Atoi(path)
+	}))
+
+	code, body := get(t, h, "/3")
+	if code != http.StatusOK || body != `{"square":9}` {
+		t.Fatalf(`Gets (%d, %s), wants (200, {"square":9})`, code, body)
+	}
+
+	if code, _ := get(t, h, "/three"); code != http.StatusBadRequest {
+		t.Fatalf("Gets %d, wants 400", code)
+	}
+
+	if code, _ := get(t, h, "/-1"); code != http.StatusBadGateway {
+		t.Fatalf("Gets %d, wants 502", code)
+	}
+
+	if code, _ := get(t, h, "/"); code != http.StatusNotFound {
+		t.Fatalf("Gets %d, wants 404", code)
+	}
+
+	cached.Close()
+	if code, _ := get(t, h, "/4"); code != http.StatusServiceUnavailable {
+		t.Fatalf("Gets %d, wants 503", code)
+	}
+}
+
+type hangingCFetcher struct{}
+
+func (hangingCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	<-cancel
+	return nil, fetchmgr.ErrFetchCanceled
+}
+
+func TestHandlerCancel(t *testing.T) {
+	s := httptest.NewServer(NewHandler(hangingCFetcher{}))
+	defer s.Close()
+
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	_, err := client.Get(s.URL + "/key")
+	if err == nil {
+		t.Fatal("Gets nil, wants timeout")
+	}
+}
+
+type errorCFetcher struct {
+	err error
+}
+
+func (ef errorCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	return nil, ef.err
+}
+
+func TestHandlerWrappedErrors(t *testing.T) {
+	wraps := map[error]int{
+		fetchmgr.ErrRateLimited:   http.StatusTooManyRequests,
+		fetchmgr.ErrFetcherClosed: http.StatusServiceUnavailable,
+		fetchmgr.ErrFetchCanceled: http.StatusGatewayTimeout,
+	}
+
+	for err, want := range wraps {
+		h := NewHandler(errorCFetcher{fmt.Errorf("upstream: %w", err)})
+		if code, _ := get(t, h, "/key"); code != want {
+			t.Fatalf("Gets %d for %v, wants %d", code, err, want)
+		}
+	}
+}
+
+func TestHandlerEncodeError(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewHandler(
+		fetchmgr.AsCFetcher{Fetcher: squareFetcher{}},
+		SetKeyDecoder(func(path string) (interface{}, error) {
+			return strconv.Atoi(path)
+		}),
+		SetValueEncoder(func(w http.ResponseWriter, v interface{}) error {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "partial")
+			return errors.New("encode error")
+		}),
+		SetLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/3", nil))
+	body := strings.TrimSpace(rec.Body.String())
+	if rec.Code != http.StatusInternalServerError || body != "Internal Server Error" {
+		t.Fatalf(`Gets (%d, %q), wants (500, "Internal Server Error")`, rec.Code, body)
+	}
+	if !strings.Contains(logs.String(), "encode error") {
+		t.Fatalf("Gets logs %q, wants the encode error", logs.String())
+	}
+}
+
+func TestHandlerHidesErrors(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewHandler(
+		errorCFetcher{errors.New("dial tcp 10.0.0.1:5432: refused")},
+		SetLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+
+	code, body := get(t, h, "/key")
+	if code != http.StatusBadGateway || strings.TrimSpace(body) != "Bad Gateway" {
+		t.Fatalf(`Gets (%d, %q), wants (502, "Bad Gateway")`, code, body)
+	}
+	if !strings.Contains(logs.String(), "10.0.0.1:5432") {
+		t.Fatalf("Gets logs %q, wants the backend error", logs.String())
+	}
+}
@@ -0,0 +1,125 @@
+package fetchmgr
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// limitedCFetcher is an instance of CFetcher which limits the number of
+// concurrent calls
+type limitedCFetcher struct {
+	sem     chan struct{}
+	closing chan struct{} // Closed by Close of limitedCFetchCloser
+	wrapped
+}
+
+// NewLimitedCFetcher makes f run at most n CFetch() calls concurrently. Other
+// calls wait for a slot until their cancel chan is closed. It panics if n is
+// less than 1.
+func NewLimitedCFetcher(f CFetcher, n int) CFetcher {
+	return newLimitedCFetcher(f, n)
+}
+
+func newLimitedCFetcher(f CFetcher, n int) limitedCFetcher {
+	if n < 1 {
+		panic(fmt.Sprintf("fetchmgr: limit %d is less than 1", n))
+	}
+	return limitedCFetcher{make(chan struct{}, n), nil, wrapped{f}}
+}
+
+// CFetch fetches a value
+func (lf limitedCFetcher) CFetch(cancel <-chan struct{}, k interface{}) (interface{}, error) {
+	v, _, err := limitCall(lf, cancel, cfetchCall(lf.fetcher, k))
+	return v, err
+}
+
+// Revalidate revalidates an old value in the same limit as CFetch
+func (lf limitedCFetcher) Revalidate(
+	cancel <-chan struct{},
+	k interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	return limitCall(lf, cancel, revalidateCall(lf.fetcher, k, old))
+}
+
+func limitCall(lf limitedCFetcher, cancel <-chan struct{}, call fetchCall) (interface{}, bool, error) {
+	select {
+	case lf.sem <- struct{}{}:
+	case <-cancel:
+		return nil, false, ErrFetchCanceled
+	case <-lf.closing:
+		return nil, false, ErrFetcherClosed
+	}
+	defer func() { <-lf.sem }()
+
+	if lf.closing == nil {
+		return call(cancel)
+	}
+
+	// Close cancels running calls as well
+	merged := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-cancel:
+			close(merged)
+		case <-lf.closing:
+			close(merged)
+		case <-done:
+		}
+	}()
+	return call(merged)
+}
+
+// limitedCFetchCloser is a limited instance of CFetchCloser
+type limitedCFetchCloser struct {
+	limitedCFetcher
+	io.Closer
+	once *sync.Once
+}
+
+// NewLimitedCFetchCloser makes fc run at most n CFetch() calls concurrently.
+// Close() cancels running CFetch() calls and waits for them before closing
+// fc. It panics if n is less than 1.
+func NewLimitedCFetchCloser(fc CFetchCloser, n int) CFetchCloser {
+	return newLimitedCFetchCloser(fc, fc, n)
+}
+
+func newLimitedCFetchCloser(f CFetcher, c io.Closer, n int) limitedCFetchCloser {
+	lf := newLimitedCFetcher(f, n)
+	lf.closing = make(chan struct{})
+	return limitedCFetchCloser{lf, c, &sync.Once{}}
+}
+
+// Close closes lfc. Calls waiting for a slot return ErrFetcherClosed.
+func (lfc limitedCFetchCloser) Close() error {
+	lfc.once.Do(func() { close(lfc.closing) })
+
+	sem := lfc.limitedCFetcher.sem
+	for i := 0; i < cap(sem); i++ {
+		sem <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < cap(sem); i++ {
+			<-sem
+		}
+	}()
+	return lfc.Closer.Close()
+}
+
+// NewLimitedFetcher makes f run at most n Fetch() calls concurrently. It
+// panics if n is less than 1.
+func NewLimitedFetcher(f Fetcher, n int) Fetcher {
+	lcf := NewLimitedCFetcher(asCFetcher(f), n)
+	return asFetcher(lcf)
+}
+
+// NewLimitedFetchCloser makes fc run at most n Fetch() calls concurrently.
+// Close() waits for all running Fetch() calls. It panics if n
+// is less than 1.
+func NewLimitedFetchCloser(fc FetchCloser, n int) FetchCloser {
+	lcfc := newLimitedCFetchCloser(asCFetcher(fc), fc, n)
+	return asFetchCloser(lcfc)
+}
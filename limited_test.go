@@ -0,0 +1,158 @@
+package fetchmgr_test
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+type concurrencyFetcher struct {
+	running int32
+	max     int32
+}
+
+func (cf *concurrencyFetcher) Fetch(key interface{}) (interface{}, error) {
+	n := atomic.AddInt32(&cf.running, 1)
+	for {
+		m := atomic.LoadInt32(&cf.max)
+		if n <= m || atomic.CompareAndSwapInt32(&cf.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&cf.running, -1)
+	return key, nil
+}
+
+func TestLimitedFetcher(t *testing.T) {
+	var f concurrencyFetcher
+	lf := NewLimitedFetcher(&f, 3)
+
+	var wg sync.WaitGroup
+	wg.Add(20)
+	for i := 0; i < 20; i++ {
+		go func() {
+			for j := 0; j < 10; j++ {
+				_, _ = lf.Fetch(nil)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&f.max); n != 3 {
+		t.Fatalf("Gets %d concurrent calls, wants 3", n)
+	}
+}
+
+func TestLimitedCFetcherCancel(t *testing.T) {
+	release := make(chan struct{})
+	blocking := AsCFetcher{FuncFetcher(func(k interface{}) (interface{}, error) {
+		<-release
+		return k, nil
+	})}
+	lf := NewLimitedCFetcher(blocking, 1)
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = lf.CFetch(nil, 1)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel := make(chan struct{})
+	close(cancel)
+	v, err := lf.CFetch(cancel, 2)
+	if err != ErrFetchCanceled {
+		t.Fatalf("Gets (%v, %v), wants ErrFetchCanceled", v, err)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestLimitedFetchCloser(t *testing.T) {
+	var f UnsafeFetcher
+	lf := NewLimitedFetchCloser(&f, 1)
+
+	fetch10000Times(t, lf)
+
+	if n := int32(f); n != -10000 {
+		t.Fatalf("Gets %d, wants -10000", n)
+	}
+}
+
+func TestLimitedCFetcherInvalidLimit(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Gets no panic for %d, wants a panic", n)
+				}
+			}()
+			NewLimitedCFetcher(new(countCFetcher), n)
+		}()
+	}
+}
+
+func TestLimitedCFetchCloserCloseCancels(t *testing.T) {
+	gf := &gateFetcher{release: make(chan struct{})}
+	closer := &closingFetcher{}
+	lf := NewLimitedCFetchCloser(struct {
+		CFetcher
+		io.Closer
+	}{gf, closer}, 1)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			_, err := lf.CFetch(nil, i)
+			errs <- err
+		}(i)
+	}
+	waitCalls(t, gf, 1) // The other call waits for the slot
+
+	closed := make(chan error)
+	go func() { closed <- lf.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hangs on the running call")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Fatal("Gets nil, wants errors for canceled calls")
+		}
+	}
+	if n := atomic.LoadInt32(&closer.closes); n != 1 {
+		t.Fatalf("Gets %d closes, wants 1", n)
+	}
+}
+
+func TestLimitedFetcherRevalidate(t *testing.T) {
+	vf := &versionedFetcher{}
+	lf, ok := NewLimitedFetcher(vf, 1).(FetchRevalidator)
+	if !ok {
+		t.Fatal("Gets no FetchRevalidator, wants Revalidate kept")
+	}
+	old, _ := lf.Fetch("doc")
+	if _, changed, err := lf.Revalidate("doc", old); changed || err != nil {
+		t.Fatalf("Gets (%v, %v), wants (false, nil)", changed, err)
+	}
+	if n := atomic.LoadInt32(&vf.revalidation); n != 1 {
+		t.Fatalf("Gets %d revalidations, wants 1", n)
+	}
+
+	var f UnsafeFetcher
+	if _, ok := NewLimitedFetchCloser(&f, 1).(FetchRevalidator); ok {
+		t.Fatal("Gets FetchRevalidator, wants a plain FetchCloser")
+	}
+}
@@ -0,0 +1,212 @@
+package fetchmgr
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// LogEvent is a kind of events which are logged
+type LogEvent int
+
+// Events to log
+const (
+	// LogFetch is a successful call of the internal fetcher
+	LogFetch LogEvent = iota
+	// LogError is a failed call of the internal fetcher
+	LogError
+	// LogSlow is a call slower than the threshold set by SetSlowThreshold
+	LogSlow
+	// LogEvict is an expiration of a cached value
+	LogEvict
+)
+
+type logSetting struct {
+	keyFunc func(interface{}) slog.Value
+	levels  map[LogEvent]slog.Level
+	slow    time.Duration
+}
+
+// LogSetting makes arguments for NewLoggingCFetcher and SetLogger
+type LogSetting func(*logSetting)
+
+// SetLogKeyFunc sets the function to format keys in logs. Use it to shorten
+// or redact keys. Keys are logged by slog.AnyValue by default.
+func SetLogKeyFunc(f func(interface{}) slog.Value) LogSetting {
+	return func(ls *logSetting) {
+		ls.keyFunc = f
+	}
+}
+
+// SetLogLevel sets the level of the event. The defaults are Debug for
+// LogFetch and LogEvict, Warn for LogSlow and Error for LogError.
+func SetLogLevel(ev LogEvent, l slog.Level) LogSetting {
+	return func(ls *logSetting) {
+		ls.levels[ev] = l
+	}
+}
+
+// SetSlowThreshold makes fetches taking d or longer logged as LogSlow.
+// Slow fetches aren't logged if d is 0, which is the default.
+func SetSlowThreshold(d time.Duration) LogSetting {
+	return func(ls *logSetting) {
+		ls.slow = d
+	}
+}
+
+// fetchLogger logs events through slog.Logger
+type fetchLogger struct {
+	logger  *slog.Logger
+	keyFunc func(interface{}) slog.Value
+	levels  map[LogEvent]slog.Level
+	slow    time.Duration
+}
+
+func newFetchLogger(logger *slog.Logger, ss []LogSetting) *fetchLogger {
+	setting := &logSetting{
+		keyFunc: slog.AnyValue,
+		levels: map[LogEvent]slog.Level{
+			LogFetch: slog.LevelDebug,
+			LogError: slog.LevelError,
+			LogSlow:  slog.LevelWarn,
+			LogEvict: slog.LevelDebug,
+		},
+	}
+
+	for _, set := range ss {
+		set(setting)
+	}
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &fetchLogger{
+		logger:  logger,
+		keyFunc: setting.keyFunc,
+		levels:  setting.levels,
+		slow:    setting.slow,
+	}
+}
+
+func logFetch(
+	fl *fetchLogger,
+	ctx context.Context,
+	key interface{},
+	d time.Duration,
+	err error,
+) {
+	switch {
+	case err != nil:
+		logEvent(fl, ctx, LogError, "fetch failed", key,
+			slog.Duration("duration", d), slog.Any("error", err))
+	case fl.slow > 0 && d >= fl.slow:
+		logEvent(fl, ctx, LogSlow, "slow fetch", key, slog.Duration("duration", d))
+	default:
+		logEvent(fl, ctx, LogFetch, "fetched", key, slog.Duration("duration", d))
+	}
+}
+
+func logEvent(
+	fl *fetchLogger,
+	ctx context.Context,
+	ev LogEvent,
+	msg string,
+	key interface{},
+	attrs ...slog.Attr,
+) {
+	level := fl.levels[ev]
+	if !fl.logger.Enabled(ctx, level) {
+		return // Don't format keys
+	}
+
+	attrs = append(attrs, slog.Attr{Key: "key", Value: fl.keyFunc(key)})
+	fl.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// LoggingCFetcher logs calls of the internal CFetcher through log/slog
+type LoggingCFetcher struct {
+	wrapped
+	logger *fetchLogger
+}
+
+// NewLoggingCFetcher creates LoggingCFetcher. slog.Default() is used if
+// logger is nil.
+func NewLoggingCFetcher(
+	fetcher CFetcher,
+	logger *slog.Logger,
+	ss ...LogSetting,
+) *LoggingCFetcher {
+	return &LoggingCFetcher{
+		wrapped: wrapped{fetcher},
+		logger:  newFetchLogger(logger, ss),
+	}
+}
+
+// CFetch calls the internal CFetcher and logs the result
+func (lf *LoggingCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	start := time.Now()
+	v, err := lf.fetcher.CFetch(cancel, key)
+	logFetch(lf.logger, context.Background(), key, time.Since(start), err)
+	return v, err
+}
+
+// CtxFetch calls the internal CFetcher with ctx, which is also passed to the
+// logger
+func (lf *LoggingCFetcher) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	start := time.Now()
+	v, err := ctxFetch(lf.fetcher, ctx, key)
+	logFetch(lf.logger, ctx, key, time.Since(start), err)
+	return v, err
+}
+
+// Revalidate revalidates the old value by the internal CFetcher and logs the
+// result as CFetch does
+func (lf *LoggingCFetcher) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	start := time.Now()
+	v, changed, err := revalidate(lf.fetcher, cancel, key, old)
+	logFetch(lf.logger, context.Background(), key, time.Since(start), err)
+	return v, changed, err
+}
+
+// Close closes the internal CFetcher if it is an io.Closer
+func (lf *LoggingCFetcher) Close() error {
+	fc, ok := lf.fetcher.(io.Closer)
+	if ok {
+		return fc.Close()
+	}
+
+	return nil
+}
+
+// logObserver logs events of caches
+type logObserver struct {
+	logger *fetchLogger
+}
+
+// SetLogger makes caches log fetches, errors, slow fetches and evictions.
+// slog.Default() is used if logger is nil.
+func SetLogger(logger *slog.Logger, ss ...LogSetting) Setting {
+	return SetCtxObserver(logObserver{newFetchLogger(logger, ss)})
+}
+
+func (lo logObserver) FetchStart(ctx context.Context, key interface{}) {}
+
+func (lo logObserver) FetchEnd(ctx context.Context, key interface{}, d time.Duration, err error) {
+	logFetch(lo.logger, ctx, key, d, err)
+}
+
+func (lo logObserver) Hit(ctx context.Context, key interface{}) {}
+
+func (lo logObserver) SharedWait(ctx context.Context, key interface{}) {}
+
+func (lo logObserver) Evict(ctx context.Context, key interface{}) {
+	logEvent(lo.logger, ctx, LogEvict, "evicted", key)
+}
+
+func (lo logObserver) Canceled(ctx context.Context, key interface{}) {}
@@ -0,0 +1,87 @@
+package fetchmgr_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func newTestLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	return slog.New(h), &buf
+}
+
+func assertLog(t *testing.T, buf *bytes.Buffer, wants string) {
+	t.Helper()
+	log := buf.String()
+	buf.Reset()
+	if strings.TrimSpace(log) != wants {
+		t.Fatalf("Gets %q, wants %q", log, wants)
+	}
+}
+
+func TestLoggingCFetcher(t *testing.T) {
+	logger, buf := newTestLogger()
+	var cnt countCFetcher
+
+	lf := NewLoggingCFetcher(&cnt, logger)
+	lf.CFetch(nil, "key")
+	assertLog(t, buf, "level=DEBUG msg=fetched key=key")
+
+	lf = NewLoggingCFetcher(
+		NewChaosCFetcher(&cnt, 1, SetErrorRate(1, nil)),
+		logger,
+		SetLogKeyFunc(func(interface{}) slog.Value { return slog.StringValue("***") }),
+	)
+	lf.CFetch(nil, "key")
+	assertLog(t, buf, `level=ERROR msg="fetch failed" error="injected failure" key=***`)
+
+	lf = NewLoggingCFetcher(
+		NewChaosCFetcher(&cnt, 1, SetLatency(FixedLatency(10*time.Millisecond))),
+		logger,
+		SetSlowThreshold(5*time.Millisecond),
+		SetLogLevel(LogSlow, slog.LevelInfo),
+	)
+	lf.CFetch(nil, "key")
+	assertLog(t, buf, `level=INFO msg="slow fetch" key=key`)
+}
+
+func TestSetLogger(t *testing.T) {
+	logger, buf := newTestLogger()
+	obs := &countObserver{events: make(map[string]int)}
+	clk := NewFakeClock(time.Now())
+	var cnt countCFetcher
+	cached := CNew(
+		&cnt,
+		SetLogger(logger),
+		SetObserver(obs),
+		SetClock(clk),
+		SetTTL(time.Minute),
+		SetInterval(time.Second),
+	)
+	defer cached.Close()
+
+	cached.CFetch(nil, "key")
+	cached.CFetch(nil, "key")
+	assertLog(t, buf, "level=DEBUG msg=fetched key=key")
+
+	clk.Advance(2 * time.Minute)
+	assertLog(t, buf, "level=DEBUG msg=evicted key=key")
+
+	if n := obs.events["FetchStart"]; n != 1 {
+		t.Fatalf("Gets %d FetchStart, wants 1", n)
+	}
+}
@@ -0,0 +1,320 @@
+// Package memcachefetchmgr stores fetched values in memcached. It's used as
+// a shared second layer behind the in-process cache of fetchmgr.
+package memcachefetchmgr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// ErrCacheMiss means the key isn't stored in memcached
+var ErrCacheMiss = errors.New("memcache: cache miss")
+
+// ErrInvalidKey means the key can't be used for memcached
+var ErrInvalidKey = errors.New("memcache: invalid key")
+
+// ServerError is the error which memcached returned
+type ServerError struct {
+	Line string
+}
+
+func (se ServerError) Error() string {
+	return "memcache: " + se.Line
+}
+
+// maxExpiration is the largest relative expiration time in seconds. Larger
+// values are treated as unix timestamps by memcached.
+const maxExpiration = 60 * 60 * 24 * 30
+
+// Client talks with a memcached server by the text protocol. It holds a pool
+// of connections.
+type Client struct {
+	addr    string
+	timeout time.Duration
+	idle    chan *conn
+}
+
+type conn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+type clientSetting struct {
+	timeout time.Duration
+	maxIdle int
+}
+
+// ClientSetting makes arguments for NewClient constracter
+type ClientSetting func(*clientSetting)
+
+// SetTimeout sets the timeout for each operation
+// The default value is 1 second.
+func SetTimeout(t time.Duration) ClientSetting {
+	return func(cs *clientSetting) {
+		cs.timeout = t
+	}
+}
+
+// SetMaxIdleConns sets the number of idle connections kept in the pool
+// The default value is 2.
+func SetMaxIdleConns(n int) ClientSetting {
+	return func(cs *clientSetting) {
+		cs.maxIdle = n
+	}
+}
+
+// NewClient creates Client for the memcached server at addr
+func NewClient(addr string, ss ...ClientSetting) *Client {
+	setting := &clientSetting{
+		timeout: 1 * time.Second,
+		maxIdle: 2,
+	}
+
+	for _, set := range ss {
+		set(setting)
+	}
+
+	return &Client{
+		addr:    addr,
+		timeout: setting.timeout,
+		idle:    make(chan *conn, setting.maxIdle),
+	}
+}
+
+// Get gets the value of key. It returns ErrCacheMiss if key isn't stored.
+func (c *Client) Get(key string) ([]byte, error) {
+	return c.CGet(nil, key)
+}
+
+// CGet is Get which can be canceled by closing cancel. Canceled calls
+// return fetchmgr.ErrFetchCanceled.
+func (c *Client) CGet(cancel <-chan struct{}, key string) ([]byte, error) {
+	var value []byte
+	err := withConn(c, cancel, key, func(cn *conn) error {
+		fmt.Fprintf(cn.rw, "get %s\r\n", key)
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readLine(cn)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return ErrCacheMiss
+		}
+
+		var k string
+		var flags, size int
+		_, err = fmt.Sscanf(line, "VALUE %s %d %d", &k, &flags, &size)
+		if err != nil {
+			return ServerError{line}
+		}
+
+		value = make([]byte, size+2)
+		if _, err := io.ReadFull(cn.rw, value); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(value, []byte("\r\n")) {
+			return ServerError{"corrupt value"}
+		}
+		value = value[:size]
+
+		line, err = readLine(cn)
+		if err != nil {
+			return err
+		}
+		if line != "END" {
+			return ServerError{line}
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+// Set stores value for key. The value expires after exp. If exp is 0, it
+// never expires.
+func (c *Client) Set(key string, value []byte, exp time.Duration) error {
+	return withConn(c, nil, key, func(cn *conn) error {
+		fmt.Fprintf(cn.rw, "set %s 0 %d %d\r\n", key, expSeconds(exp), len(value))
+		cn.rw.Write(value)
+		cn.rw.WriteString("\r\n")
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readLine(cn)
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			return ServerError{line}
+		}
+		return nil
+	})
+}
+
+// Delete deletes key. It returns ErrCacheMiss if key isn't stored.
+func (c *Client) Delete(key string) error {
+	return withConn(c, nil, key, func(cn *conn) error {
+		fmt.Fprintf(cn.rw, "delete %s\r\n", key)
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readLine(cn)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "DELETED":
+			return nil
+		case "NOT_FOUND":
+			return ErrCacheMiss
+		}
+		return ServerError{line}
+	})
+}
+
+// Close closes idle connections
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.nc.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func withConn(c *Client, cancel <-chan struct{}, key string, f func(*conn) error) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	select {
+	case <-cancel:
+		return fetchmgr.ErrFetchCanceled
+	default:
+	}
+
+	cn, err := getConn(c)
+	if err != nil {
+		return err
+	}
+
+	cn.nc.SetDeadline(time.Now().Add(c.timeout))
+
+	stop := watchCancel(cn, cancel)
+	err = f(cn)
+	canceled := stop()
+
+	// Only connections which read the whole reply can be reused
+	if (err == nil || err == ErrCacheMiss) && !canceled {
+		putConn(c, cn)
+	} else {
+		cn.nc.Close()
+	}
+
+	if err != nil && canceled {
+		return fetchmgr.ErrFetchCanceled
+	}
+	return err
+}
+
+// watchCancel interrupts I/O of cn when cancel is closed. The returned
+// function stops watching and reports whether cn was interrupted.
+func watchCancel(cn *conn, cancel <-chan struct{}) func() bool {
+	if cancel == nil {
+		return func() bool { return false }
+	}
+
+	done := make(chan struct{})
+	result := make(chan bool, 1)
+	go func() {
+		select {
+		case <-cancel:
+			cn.nc.SetDeadline(time.Now())
+			result <- true
+		case <-done:
+			result <- false
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		return <-result
+	}
+}
+
+func getConn(c *Client) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	rw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	return &conn{nc, rw}, nil
+}
+
+func putConn(c *Client, cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.nc.Close() // The pool is full
+	}
+}
+
+func readLine(cn *conn) (string, error) {
+	line, err := cn.rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") ||
+		strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", ServerError{line}
+	}
+	return line, nil
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func expSeconds(exp time.Duration) int {
+	if exp <= 0 {
+		return 0
+	}
+
+	sec := int((exp + time.Second - 1) / time.Second)
+	if sec > maxExpiration {
+		return int(time.Now().Unix()) + sec
+	}
+	return sec
+}
@@ -0,0 +1,150 @@
+package memcachefetchmgr
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// Codec converts values to bytes to store them in memcached
+type Codec = fetchmgr.Codec
+
+// ErrUnsupportedValue means the codec can't encode the value
+var ErrUnsupportedValue = fetchmgr.ErrUnsupportedValue
+
+// BytesCodec is the default Codec. It only supports []byte values.
+type BytesCodec = fetchmgr.BytesCodec
+
+// JSONCodec encodes values into JSON
+type JSONCodec = fetchmgr.JSONCodec
+
+// KeyFunc makes a memcached key from a key of fetchers
+type KeyFunc func(interface{}) string
+
+// CFetcher looks up memcached before calling the internal fetcher, and
+// stores its results in memcached. Errors of memcached are ignored, and the
+// internal fetcher is used instead.
+type CFetcher struct {
+	client  *Client
+	fetcher fetchmgr.CFetcher
+	codec   Codec
+	key     KeyFunc
+	ttl     time.Duration
+}
+
+type fetcherSetting struct {
+	codec Codec
+	key   KeyFunc
+	ttl   time.Duration
+}
+
+// Setting makes arguments for New constracter
+type Setting func(*fetcherSetting)
+
+// SetCodec sets the codec to store values
+// The default codec is BytesCodec.
+func SetCodec(c Codec) Setting {
+	return func(fs *fetcherSetting) {
+		fs.codec = c
+	}
+}
+
+// SetKeyFunc sets the function to make memcached keys
+// The default function formats keys by fmt.Sprint.
+func SetKeyFunc(f KeyFunc) Setting {
+	return func(fs *fetcherSetting) {
+		fs.key = f
+	}
+}
+
+// SetPrefix makes memcached keys from prefix and keys formatted by
+// fmt.Sprint
+func SetPrefix(prefix string) Setting {
+	return func(fs *fetcherSetting) {
+		fs.key = func(k interface{}) string {
+			return prefix + fmt.Sprint(k)
+		}
+	}
+}
+
+// SetTTL sets the expiration time of values in memcached
+// The default value is 10 minutes. Values which implement fetchmgr.Expirer
+// decide their own expiration time.
+func SetTTL(t time.Duration) Setting {
+	return func(fs *fetcherSetting) {
+		fs.ttl = t
+	}
+}
+
+// New creates CFetcher
+func New(client *Client, fetcher fetchmgr.CFetcher, ss ...Setting) *CFetcher {
+	setting := &fetcherSetting{
+		codec: BytesCodec{},
+		key:   func(k interface{}) string { return fmt.Sprint(k) },
+		ttl:   10 * time.Minute,
+	}
+
+	for _, set := range ss {
+		set(setting)
+	}
+
+	return &CFetcher{
+		client:  client,
+		fetcher: fetcher,
+		codec:   setting.codec,
+		key:     setting.key,
+		ttl:     setting.ttl,
+	}
+}
+
+// CFetch fetches values from memcached or the internal fetcher
+func (mf *CFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	k := mf.key(key)
+
+	b, err := mf.client.CGet(cancel, k)
+	if err == fetchmgr.ErrFetchCanceled {
+		return nil, err
+	}
+	if err == nil {
+		v, err := mf.codec.Decode(b)
+		if err == nil {
+			return v, nil
+		}
+	}
+
+	v, err := mf.fetcher.CFetch(cancel, key)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := mf.ttl
+	if e, ok := v.(fetchmgr.Expirer); ok && !e.Expires().IsZero() {
+		ttl = time.Until(e.Expires())
+	}
+	if ttl <= 0 {
+		return v, nil // Already expired
+	}
+
+	if b, err := mf.codec.Encode(v); err == nil {
+		mf.client.Set(k, b, ttl)
+	}
+
+	return v, nil
+}
+
+// Delete deletes the value of key from memcached
+func (mf *CFetcher) Delete(key interface{}) error {
+	return mf.client.Delete(mf.key(key))
+}
+
+// Close closes the internal fetcher if it is an io.Closer
+func (mf *CFetcher) Close() error {
+	fc, ok := mf.fetcher.(io.Closer)
+	if ok {
+		return fc.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package memcachefetchmgr_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+	. "github.com/hiratara/fetchmgr/memcachefetchmgr"
+	"github.com/hiratara/fetchmgr/memcachefetchmgr/memcachetest"
+)
+
+func newServer(t *testing.T) *memcachetest.Server {
+	s, err := memcachetest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to start the server: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestClient(t *testing.T) {
+	s := newServer(t)
+	c := NewClient(s.Addr)
+	defer c.Close()
+
+	if _, err := c.Get("key"); err != ErrCacheMiss {
+		t.Fatalf("Gets %v, wants ErrCacheMiss", err)
+	}
+
+	value := []byte("multi\r\nline value")
+	if err := c.Set("key", value, 0); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			b, err := c.Get("key")
+			if err != nil || string(b) != string(value) {
+				t.Errorf("Gets (%q, %v), wants (%q, nil)", b, err, value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := c.Delete("key"); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+	if err := c.Delete("key"); err != ErrCacheMiss {
+		t.Fatalf("Gets %v, wants ErrCacheMiss", err)
+	}
+
+	if err := c.Set("bad key", value, 0); err != ErrInvalidKey {
+		t.Fatalf("Gets %v, wants ErrInvalidKey", err)
+	}
+	if err := c.Set(strings.Repeat("k", 251), value, 0); err != ErrInvalidKey {
+		t.Fatalf("Gets %v, wants ErrInvalidKey", err)
+	}
+}
+
+func TestClientExpiration(t *testing.T) {
+	s := newServer(t)
+	c := NewClient(s.Addr)
+	defer c.Close()
+
+	if err := c.Set("key", []byte("value"), time.Second); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+	if _, err := c.Get("key"); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := c.Get("key"); err != ErrCacheMiss {
+		t.Fatalf("Gets %v, wants ErrCacheMiss", err)
+	}
+}
+
+type countFetcher int32
+
+func (cnt *countFetcher) Fetch(key interface{}) (interface{}, error) {
+	atomic.AddInt32((*int32)(cnt), 1)
+	return []byte("value of " + key.(string)), nil
+}
+
+func TestCFetcher(t *testing.T) {
+	s := newServer(t)
+	c := NewClient(s.Addr)
+	defer c.Close()
+
+	// 2 processes share the same memcached
+	var cnt countFetcher
+	for i := 0; i < 2; i++ {
+		mf := New(c, fetchmgr.AsCFetcher{Fetcher: &cnt}, SetPrefix("test:"))
+		cached := fetchmgr.CNew(mf)
+
+		v, err := cached.CFetch(nil, "key")
+		if err != nil || string(v.([]byte)) != "value of key" {
+			t.Fatalf(`Gets (%v, %v), wants ("value of key", nil)`, v, err)
+		}
+		cached.Close()
+	}
+
+	if n := atomic.LoadInt32((*int32)(&cnt)); n != 1 {
+		t.Fatalf("Gets %d fetches, wants 1", n)
+	}
+	if _, err := c.Get("test:key"); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+}
+
+func TestCFetcherServerDown(t *testing.T) {
+	s := newServer(t)
+	c := NewClient(s.Addr, SetTimeout(100*time.Millisecond))
+	defer c.Close()
+	s.Close()
+
+	var cnt countFetcher
+	mf := New(c, fetchmgr.AsCFetcher{Fetcher: &cnt})
+	v, err := mf.CFetch(nil, "key")
+	if err != nil || string(v.([]byte)) != "value of key" {
+		t.Fatalf(`Gets (%v, %v), wants ("value of key", nil)`, v, err)
+	}
+}
+
+// rawServer accepts connections and replies to each request line by reply
+func rawServer(t *testing.T, reply func(line string) string) (string, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var accepted int32
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			t.Cleanup(func() { nc.Close() })
+
+			go func() {
+				r := bufio.NewReader(nc)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					nc.Write([]byte(reply(line)))
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), &accepted
+}
+
+func TestClientBrokenReply(t *testing.T) {
+	addr, accepted := rawServer(t, func(string) string {
+		return "VALUE key 0 3\r\nabcXX\r\nEND\r\n"
+	})
+	c := NewClient(addr)
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get("key"); err == nil {
+			t.Fatalf("Gets nil, wants an error")
+		}
+	}
+
+	if n := atomic.LoadInt32(accepted); n != 2 {
+		t.Fatalf("Gets %d connections, wants 2", n)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	addr, accepted := rawServer(t, func(string) string {
+		return "" // Never replies
+	})
+	c := NewClient(addr, SetTimeout(10*time.Second))
+	defer c.Close()
+
+	cancel := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(cancel) })
+
+	start := time.Now()
+	if _, err := c.CGet(cancel, "key"); err != fetchmgr.ErrFetchCanceled {
+		t.Fatalf("Gets %v, wants ErrFetchCanceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Gets %v, wants returning soon after canceled", d)
+	}
+
+	if _, err := c.CGet(cancel, "key"); err != fetchmgr.ErrFetchCanceled {
+		t.Fatalf("Gets %v, wants ErrFetchCanceled", err)
+	}
+	if n := atomic.LoadInt32(accepted); n != 1 {
+		t.Fatalf("Gets %d connections, wants 1", n)
+	}
+}
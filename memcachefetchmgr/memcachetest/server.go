@@ -0,0 +1,216 @@
+// Package memcachetest provides a tiny memcached server for tests. It speaks
+// get, set and delete commands of the text protocol.
+package memcachetest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxExpiration is the largest relative expiration time in seconds
+const maxExpiration = 60 * 60 * 24 * 30
+
+// Server is a memcached server listening on localhost
+type Server struct {
+	Addr     string
+	listener net.Listener
+	mutex    sync.Mutex
+	items    map[string]item
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+type item struct {
+	flags  int
+	value  []byte
+	expire time.Time
+}
+
+// NewServer starts a new server
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Addr:     l.Addr().String(),
+		listener: l,
+		items:    make(map[string]item),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go acceptLoop(s)
+
+	return s, nil
+}
+
+// Close stops the server and closes all connections
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mutex.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Len returns the number of stored items including expired ones
+func (s *Server) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.items)
+}
+
+func acceptLoop(s *Server) {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.conns[c] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go serve(s, c)
+	}
+}
+
+func serve(s *Server, c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+		c.Close()
+	}()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			rw.WriteString("ERROR\r\n")
+		} else {
+			switch fields[0] {
+			case "get":
+				get(s, rw, fields[1:])
+			case "set":
+				if err := set(s, rw, fields[1:]); err != nil {
+					return
+				}
+			case "delete":
+				del(s, rw, fields[1:])
+			default:
+				rw.WriteString("ERROR\r\n")
+			}
+		}
+
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func get(s *Server, rw *bufio.ReadWriter, keys []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, k := range keys {
+		it, ok := s.items[k]
+		if !ok {
+			continue
+		}
+		if !it.expire.IsZero() && !now.Before(it.expire) {
+			delete(s.items, k)
+			continue
+		}
+
+		fmt.Fprintf(rw, "VALUE %s %d %d\r\n", k, it.flags, len(it.value))
+		rw.Write(it.value)
+		rw.WriteString("\r\n")
+	}
+	rw.WriteString("END\r\n")
+}
+
+func set(s *Server, rw *bufio.ReadWriter, args []string) error {
+	if len(args) < 4 {
+		rw.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	flags, err1 := strconv.Atoi(args[1])
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		rw.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(rw, data); err != nil {
+		return err
+	}
+	if string(data[size:]) != "\r\n" {
+		rw.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return nil
+	}
+
+	var expire time.Time
+	switch {
+	case exptime < 0:
+		expire = time.Now()
+	case exptime > maxExpiration:
+		expire = time.Unix(exptime, 0)
+	case exptime > 0:
+		expire = time.Now().Add(time.Duration(exptime) * time.Second)
+	}
+
+	s.mutex.Lock()
+	s.items[args[0]] = item{flags, data[:size], expire}
+	s.mutex.Unlock()
+
+	if len(args) < 5 || args[4] != "noreply" {
+		rw.WriteString("STORED\r\n")
+	}
+	return nil
+}
+
+func del(s *Server, rw *bufio.ReadWriter, args []string) {
+	if len(args) < 1 {
+		rw.WriteString("ERROR\r\n")
+		return
+	}
+
+	s.mutex.Lock()
+	_, ok := s.items[args[0]]
+	delete(s.items, args[0])
+	s.mutex.Unlock()
+
+	if ok {
+		rw.WriteString("DELETED\r\n")
+	} else {
+		rw.WriteString("NOT_FOUND\r\n")
+	}
+}
@@ -0,0 +1,116 @@
+package fetchmgr
+
+import (
+	"context"
+	"time"
+)
+
+// Observer receives events of CachedCFetcher. Use it to collect metrics or
+// traces. Methods are called synchronously, so they should return quickly.
+type Observer interface {
+	// FetchStart is called when the internal fetcher is called
+	FetchStart(key interface{})
+	// FetchEnd is called when the internal fetcher returns
+	FetchEnd(key interface{}, d time.Duration, err error)
+	// Hit is called when the cached value is found
+	Hit(key interface{})
+	// SharedWait is called when the caller waits for the value which
+	// another caller is fetching
+	SharedWait(key interface{})
+	// Evict is called when the cached value is expired
+	Evict(key interface{})
+	// Canceled is called when the caller cancels waiting
+	Canceled(key interface{})
+}
+
+// CtxObserver is Observer which also receives the caller's context.
+// FetchStart and FetchEnd receive the context passed to the internal
+// fetcher, which holds values of the caller's context. Evict receives
+// context.Background() because it's not caused by callers.
+type CtxObserver interface {
+	FetchStart(ctx context.Context, key interface{})
+	FetchEnd(ctx context.Context, key interface{}, d time.Duration, err error)
+	Hit(ctx context.Context, key interface{})
+	SharedWait(ctx context.Context, key interface{})
+	Evict(ctx context.Context, key interface{})
+	Canceled(ctx context.Context, key interface{})
+}
+
+// ctxObserver makes CtxObserver from Observer
+type ctxObserver struct {
+	o Observer
+}
+
+func (co ctxObserver) FetchStart(ctx context.Context, key interface{}) {
+	co.o.FetchStart(key)
+}
+
+func (co ctxObserver) FetchEnd(ctx context.Context, key interface{}, d time.Duration, err error) {
+	co.o.FetchEnd(key, d, err)
+}
+
+func (co ctxObserver) Hit(ctx context.Context, key interface{}) {
+	co.o.Hit(key)
+}
+
+func (co ctxObserver) SharedWait(ctx context.Context, key interface{}) {
+	co.o.SharedWait(key)
+}
+
+func (co ctxObserver) Evict(ctx context.Context, key interface{}) {
+	co.o.Evict(key)
+}
+
+func (co ctxObserver) Canceled(ctx context.Context, key interface{}) {
+	co.o.Canceled(key)
+}
+
+// multiObserver calls all observers in order
+type multiObserver []CtxObserver
+
+func addObserver(cf *fetcherSetting, o CtxObserver) {
+	switch mo := cf.observer.(type) {
+	case nil:
+		cf.observer = o
+	case multiObserver:
+		cf.observer = append(mo[:len(mo):len(mo)], o)
+	default:
+		cf.observer = multiObserver{mo, o}
+	}
+}
+
+func (mo multiObserver) FetchStart(ctx context.Context, key interface{}) {
+	for _, o := range mo {
+		o.FetchStart(ctx, key)
+	}
+}
+
+func (mo multiObserver) FetchEnd(ctx context.Context, key interface{}, d time.Duration, err error) {
+	for _, o := range mo {
+		o.FetchEnd(ctx, key, d, err)
+	}
+}
+
+func (mo multiObserver) Hit(ctx context.Context, key interface{}) {
+	for _, o := range mo {
+		o.Hit(ctx, key)
+	}
+}
+
+func (mo multiObserver) SharedWait(ctx context.Context, key interface{}) {
+	for _, o := range mo {
+		o.SharedWait(ctx, key)
+	}
+}
+
+func (mo multiObserver) Evict(ctx context.Context, key interface{}) {
+	for _, o := range mo {
+		o.Evict(ctx, key)
+	}
+}
+
+func (mo multiObserver) Canceled(ctx context.Context, key interface{}) {
+	for _, o := range mo {
+		o.Canceled(ctx, key)
+	}
+}
@@ -0,0 +1,85 @@
+package fetchmgr_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+type countObserver struct {
+	mutex  sync.Mutex
+	events map[string]int
+}
+
+func (co *countObserver) add(event string) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
+	co.events[event]++
+}
+
+func (co *countObserver) FetchStart(key interface{}) {
+	co.add("FetchStart")
+}
+
+func (co *countObserver) FetchEnd(key interface{}, d time.Duration, err error) {
+	if err == nil {
+		co.add("FetchEnd")
+	}
+}
+
+func (co *countObserver) Hit(key interface{}) {
+	co.add("Hit")
+}
+
+func (co *countObserver) SharedWait(key interface{}) {
+	co.add("SharedWait")
+}
+
+func (co *countObserver) Evict(key interface{}) {
+	co.add("Evict")
+}
+
+func (co *countObserver) Canceled(key interface{}) {
+	co.add("Canceled")
+}
+
+func TestObserver(t *testing.T) {
+	obs := &countObserver{events: make(map[string]int)}
+	gf := &gateFetcher{release: make(chan struct{})}
+	clk := NewFakeClock(time.Now())
+	cached := CNew(
+		gf,
+		SetObserver(obs),
+		SetClock(clk),
+		SetTTL(time.Minute),
+		SetInterval(time.Second),
+	)
+	defer cached.Close()
+
+	done := make(chan struct{})
+	go func() {
+		cached.CFetch(nil, "key")
+		close(done)
+	}()
+	waitCalls(t, gf, 1)
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if _, err := cached.CFetch(cancel, "key"); err != ErrFetchCanceled {
+		t.Fatalf("Gets %v, wants ErrFetchCanceled", err)
+	}
+
+	close(gf.release)
+	<-done
+	cached.CFetch(nil, "key")
+	clk.Advance(2 * time.Minute)
+
+	for _, ev := range []string{"FetchStart", "FetchEnd", "Hit", "SharedWait", "Evict", "Canceled"} {
+		if n := obs.events[ev]; n != 1 {
+			t.Fatalf("Gets %d %s, wants 1", n, ev)
+		}
+	}
+}
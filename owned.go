@@ -0,0 +1,102 @@
+package fetchmgr
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// ownedCFetcher owns the fetcher and the wheel shared by buckets. It closes
+// the fetcher only once after closing buckets.
+type ownedCFetcher struct {
+	buckets CFetchCloser
+	fetcher CFetcher
+	wheel   *timingWheel
+	once    sync.Once
+	err     error
+}
+
+// CFetch calls one of buckets
+func (of *ownedCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	return of.buckets.CFetch(cancel, key)
+}
+
+// CtxFetch calls one of buckets with context.Context
+func (of *ownedCFetcher) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return ctxFetch(of.buckets, ctx, key)
+}
+
+// Close closes buckets and the fetcher. It's safe to call Close multiple
+// times, and they return the same error.
+func (of *ownedCFetcher) Close() error {
+	return closeOwned(of, of.buckets.Close())
+}
+
+// Shutdown shuts down buckets gracefully, then closes the fetcher
+func (of *ownedCFetcher) Shutdown(cancel <-chan struct{}) error {
+	return closeOwned(of, shutdown(of.buckets, cancel))
+}
+
+// closeOwned closes the fetcher after buckets are closed with err
+func closeOwned(of *ownedCFetcher, err error) error {
+	of.once.Do(func() {
+		releaseWheel(of.wheel)
+
+		var errs InnerErrors
+		if ies, ok := err.(InnerErrors); ok {
+			errs = append(errs, ies...)
+		} else if err != nil {
+			errs = append(errs, InnerError{of.buckets, err})
+		}
+
+		if fc, ok := of.fetcher.(io.Closer); ok {
+			if cerr := fc.Close(); cerr != nil {
+				errs = append(errs, InnerError{of.fetcher, cerr})
+			}
+		}
+
+		if len(errs) > 0 {
+			of.err = errs
+		}
+	})
+
+	return of.err
+}
+
+// CachedRing is made by CNew with SetVirtualNodes. Its buckets are placed on
+// RingCFetcher and named "0", "1", ... Buckets can be added and removed while
+// fetching, and all of them share the fetcher and the settings.
+type CachedRing struct {
+	*ownedCFetcher
+	ring    *RingCFetcher
+	setting *fetcherSetting
+}
+
+// AddBucket adds a new bucket named name to the ring. It gets weight times as
+// many keys as a bucket with weight 1. A bucket which has the same name is
+// replaced and closed. It returns ErrFetcherClosed after Close.
+func (cr *CachedRing) AddBucket(name string, weight int) error {
+	if !retainWheel(cr.wheel) {
+		return ErrFetcherClosed
+	}
+
+	bucket := newSharedCachedCFetcher(cr.fetcher, cr.setting, cr.wheel)
+	if old := cr.ring.Add(name, bucket, weight); old != nil {
+		return old.(io.Closer).Close()
+	}
+	return nil
+}
+
+// RemoveBucket removes the bucket named name from the ring and closes it.
+// Its keys move to other buckets. It does nothing if there is no such bucket.
+func (cr *CachedRing) RemoveBucket(name string) error {
+	if old := cr.ring.Remove(name); old != nil {
+		return old.(io.Closer).Close()
+	}
+	return nil
+}
+
+// Pick returns the name of the bucket which is responsible for key
+func (cr *CachedRing) Pick(key interface{}) (string, bool) {
+	return cr.ring.Pick(key)
+}
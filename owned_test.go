@@ -0,0 +1,74 @@
+package fetchmgr_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+type closingFetcher struct {
+	closes int32
+	err    error
+}
+
+func (cf *closingFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	return key, nil
+}
+
+func (cf *closingFetcher) Close() error {
+	atomic.AddInt32(&cf.closes, 1)
+	return cf.err
+}
+
+func TestCNewClosesOnce(t *testing.T) {
+	for _, ss := range [][]Setting{nil, {SetVirtualNodes(10)}} {
+		cf := &closingFetcher{}
+		cached := CNew(cf, ss...)
+		for i := 0; i < 100; i++ {
+			_, _ = cached.CFetch(nil, i)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := cached.Close(); err != nil {
+					t.Errorf("Gets %v, wants nil", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&cf.closes); n != 1 {
+			t.Fatalf("Gets %d closes, wants 1", n)
+		}
+	}
+}
+
+func TestInnerErrors(t *testing.T) {
+	bf := NewBucketedCFetcher([]CFetcher{&closingFetcher{}, &closingFetcher{}})
+	if err := bf.Close(); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+
+	errClose := errors.New("close error")
+	cf := &closingFetcher{err: errClose}
+	cached := CNew(cf)
+	err := cached.Close()
+	if !errors.Is(err, errClose) {
+		t.Fatalf("Gets %v, wants errClose", err)
+	}
+
+	var ie InnerError
+	if !errors.As(err, &ie) || ie.Fetcher != cf {
+		t.Fatalf("Gets %v, wants InnerError of the fetcher", err)
+	}
+
+	if err2 := cached.Close(); err2 == nil || err2.Error() != err.Error() {
+		t.Fatalf("Gets %v, wants %v", err2, err)
+	}
+}
@@ -0,0 +1,15 @@
+package peerfetchmgr
+
+import "github.com/hiratara/fetchmgr"
+
+// Codec converts values to bytes to send them to other peers
+type Codec = fetchmgr.Codec
+
+// ErrUnsupportedValue means the codec can't encode the value
+var ErrUnsupportedValue = fetchmgr.ErrUnsupportedValue
+
+// BytesCodec is the default Codec. It only supports []byte values.
+type BytesCodec = fetchmgr.BytesCodec
+
+// JSONCodec encodes values into JSON
+type JSONCodec = fetchmgr.JSONCodec
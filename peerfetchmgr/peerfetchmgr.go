@@ -0,0 +1,329 @@
+// Package peerfetchmgr makes multiple processes act as one logical cache.
+// Each key is owned by one of peers chosen by the consistent hashing, and
+// other peers fetch values from the owner over HTTP. So each key is loaded
+// once for the whole cluster.
+package peerfetchmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// DefaultBasePath is the path prefix to serve values for other peers
+const DefaultBasePath = "/_fetchmgr/"
+
+// ErrNotStringKey means the key isn't a string. Only string keys can be sent
+// to other peers.
+var ErrNotStringKey = errors.New("key is not a string")
+
+// PeerError is the error which the owner peer returned
+type PeerError struct {
+	Peer    string
+	Message string
+}
+
+func (pe PeerError) Error() string {
+	return fmt.Sprintf("%s: %s", pe.Peer, pe.Message)
+}
+
+// Group is a cache shared among peers. Group is also an http.Handler which
+// serves values to other peers.
+type Group struct {
+	name     string
+	self     string
+	basePath string
+	codec    Codec
+	peers    *fetchmgr.RingCFetcher
+	local    fetchmgr.CFetchCloser
+	remote   fetchmgr.CFetchCloser // Shares concurrent requests to peers
+	hot      fetchmgr.CFetchCloser
+
+	hotThreshold int
+	hotWindow    time.Duration
+	hotMutex     sync.Mutex
+	hotCounts    map[string]int
+	hotReset     time.Time
+}
+
+type groupSetting struct {
+	basePath     string
+	codec        Codec
+	client       *http.Client
+	vnodes       int
+	cache        []fetchmgr.Setting
+	hotThreshold int
+	hotWindow    time.Duration
+	hotTTL       time.Duration
+}
+
+// Setting makes arguments for NewGroup constracter
+type Setting func(*groupSetting)
+
+// SetBasePath sets the path prefix to serve values for other peers
+func SetBasePath(path string) Setting {
+	return func(gs *groupSetting) {
+		gs.basePath = path
+	}
+}
+
+// SetCodec sets the codec to send values to other peers
+// The default codec is BytesCodec.
+func SetCodec(c Codec) Setting {
+	return func(gs *groupSetting) {
+		gs.codec = c
+	}
+}
+
+// SetHTTPClient sets the client to access other peers
+func SetHTTPClient(c *http.Client) Setting {
+	return func(gs *groupSetting) {
+		gs.client = c
+	}
+}
+
+// SetVirtualNodes sets the number of virtual nodes for each peer
+func SetVirtualNodes(n int) Setting {
+	return func(gs *groupSetting) {
+		gs.vnodes = n
+	}
+}
+
+// SetCacheSettings sets settings for the cache of keys owned by this peer
+func SetCacheSettings(ss ...fetchmgr.Setting) Setting {
+	return func(gs *groupSetting) {
+		gs.cache = ss
+	}
+}
+
+// SetHotKeys makes this peer replicate values owned by other peers for ttl
+// when they are requested threshold times within window.
+// Hot keys are disabled by default.
+func SetHotKeys(threshold int, window time.Duration, ttl time.Duration) Setting {
+	return func(gs *groupSetting) {
+		gs.hotThreshold = threshold
+		gs.hotWindow = window
+		gs.hotTTL = ttl
+	}
+}
+
+// NewGroup creates Group. self is the base URL of this peer, like
+// "http://10.0.0.1:8080", and peers are the base URLs of all peers including
+// self. loader loads values of keys owned by this peer, or keys of peers
+// which are down.
+func NewGroup(
+	name string,
+	self string,
+	peers []string,
+	loader fetchmgr.CFetcher,
+	ss ...Setting,
+) *Group {
+	setting := &groupSetting{
+		basePath: DefaultBasePath,
+		codec:    BytesCodec{},
+		client:   http.DefaultClient,
+	}
+
+	for _, set := range ss {
+		set(setting)
+	}
+
+	g := &Group{
+		name:         name,
+		self:         self,
+		basePath:     setting.basePath,
+		codec:        setting.codec,
+		peers:        fetchmgr.NewRingCFetcher(setting.vnodes),
+		local:        fetchmgr.CNew(loader, setting.cache...),
+		hotThreshold: setting.hotThreshold,
+		hotWindow:    setting.hotWindow,
+		hotCounts:    make(map[string]int),
+	}
+
+	for _, p := range peers {
+		if p == self {
+			g.peers.Add(p, g.local, 1)
+			continue
+		}
+
+		hp := &httpPeer{
+			group:  g,
+			base:   strings.TrimRight(p, "/") + setting.basePath,
+			client: setting.client,
+		}
+		g.peers.Add(p, fetchmgr.FromCtxFetcher{CtxFetcher: hp}, 1)
+	}
+
+	// Hide Close() not to close peers from caches of remote values
+	remote := struct{ fetchmgr.CFetcher }{g.peers}
+	g.remote = fetchmgr.CNew(remote, fetchmgr.SetTTL(0))
+	if g.hotThreshold > 0 {
+		g.hot = fetchmgr.CNew(remote, fetchmgr.SetTTL(setting.hotTTL))
+	}
+
+	return g
+}
+
+// Owner returns the base URL of the peer which owns key
+func (g *Group) Owner(key string) string {
+	owner, _ := g.peers.Pick(key)
+	return owner
+}
+
+// CFetch fetches values from this peer or the owner peer
+func (g *Group) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	k, ok := key.(string)
+	if !ok {
+		return nil, ErrNotStringKey
+	}
+
+	if g.Owner(k) == g.self {
+		return g.local.CFetch(cancel, k)
+	}
+
+	if isHot(g, k) {
+		return g.hot.CFetch(cancel, k)
+	}
+
+	return g.remote.CFetch(cancel, k)
+}
+
+// CtxFetch is CFetch with context.Context
+func (g *Group) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	k, ok := key.(string)
+	if !ok {
+		return nil, ErrNotStringKey
+	}
+
+	if g.Owner(k) == g.self {
+		return fetchmgr.AsCtxFetcher{CFetcher: g.local}.Fetch(ctx, k)
+	}
+
+	if isHot(g, k) {
+		return fetchmgr.AsCtxFetcher{CFetcher: g.hot}.Fetch(ctx, k)
+	}
+
+	return fetchmgr.AsCtxFetcher{CFetcher: g.remote}.Fetch(ctx, k)
+}
+
+// Close closes caches of this peer. It returns errors of all caches.
+func (g *Group) Close() error {
+	var hotErr error
+	if g.hot != nil {
+		hotErr = g.hot.Close()
+	}
+	return errors.Join(hotErr, g.remote.Close(), g.local.Close())
+}
+
+// isHot counts requests for key and reports whether key is a hot key
+func isHot(g *Group, key string) bool {
+	if g.hotThreshold <= 0 {
+		return false // Lock nothing
+	}
+
+	g.hotMutex.Lock()
+	defer g.hotMutex.Unlock()
+
+	if now := time.Now(); now.After(g.hotReset) {
+		g.hotCounts = make(map[string]int)
+		g.hotReset = now.Add(g.hotWindow)
+	}
+
+	g.hotCounts[key]++
+	return g.hotCounts[key] > g.hotThreshold
+}
+
+// ServeHTTP serves values of keys owned by this peer
+func (g *Group) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), g.basePath)
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	name, err1 := url.PathUnescape(parts[0])
+	key, err2 := url.PathUnescape(parts[1])
+	if err1 != nil || err2 != nil || name != g.name {
+		http.NotFound(w, r)
+		return
+	}
+
+	v, err := fetchmgr.AsCtxFetcher{CFetcher: g.local}.Fetch(r.Context(), key)
+	if err == nil {
+		var b []byte
+		b, err = g.codec.Encode(v)
+		if err == nil {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(b)
+			return
+		}
+	}
+
+	if errors.Is(err, fetchmgr.ErrFetcherClosed) {
+		// Let the peer load it by itself
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// httpPeer fetches values from another peer
+type httpPeer struct {
+	group  *Group
+	base   string
+	client *http.Client
+}
+
+// Fetch fetches values from the peer. It loads values locally when the peer
+// is down, which means transport errors or 5xx other than 500. 500 carries
+// the error of the owner's loader, and other statuses are returned as
+// PeerError.
+func (hp *httpPeer) Fetch(ctx context.Context, key interface{}) (interface{}, error) {
+	k := key.(string)
+	u := hp.base + url.PathEscape(hp.group.name) + "/" + url.PathEscape(k)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := hp.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fetchmgr.ErrFetchCanceled
+		}
+		return fallback(hp, ctx, k)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fallback(hp, ctx, k)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return hp.group.codec.Decode(b)
+	case res.StatusCode == http.StatusInternalServerError:
+		msg := strings.TrimRight(string(b), "\n")
+		return nil, PeerError{hp.base, msg}
+	case res.StatusCode >= 500:
+		return fallback(hp, ctx, k)
+	}
+
+	return nil, PeerError{hp.base, res.Status}
+}
+
+// fallback loads values locally because the peer is unavailable
+func fallback(hp *httpPeer, ctx context.Context, key string) (interface{}, error) {
+	return fetchmgr.AsCtxFetcher{CFetcher: hp.group.local}.Fetch(ctx, key)
+}
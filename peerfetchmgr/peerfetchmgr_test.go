@@ -0,0 +1,245 @@
+package peerfetchmgr_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+	. "github.com/hiratara/fetchmgr/peerfetchmgr"
+)
+
+type countLoader struct {
+	loads int32
+}
+
+func (cl *countLoader) Fetch(key interface{}) (interface{}, error) {
+	atomic.AddInt32(&cl.loads, 1)
+	switch key {
+	case "broken":
+		return nil, errors.New("broken key")
+	case "slow":
+		time.Sleep(50 * time.Millisecond)
+	}
+	return []byte("value of " + key.(string)), nil
+}
+
+type cluster struct {
+	servers []*httptest.Server
+	groups  []*Group
+	loaders []*countLoader
+	served  int32
+}
+
+func newCluster(t *testing.T, n int, ss ...Setting) *cluster {
+	c := &cluster{}
+
+	var urls []string
+	for i := 0; i < n; i++ {
+		s := httptest.NewUnstartedServer(nil)
+		c.servers = append(c.servers, s)
+		urls = append(urls, "http://"+s.Listener.Addr().String())
+	}
+
+	for i, s := range c.servers {
+		l := &countLoader{}
+		g := NewGroup("test", urls[i], urls, fetchmgr.AsCFetcher{Fetcher: l}, ss...)
+		s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&c.served, 1)
+			g.ServeHTTP(w, r)
+		})
+		s.Start()
+
+		c.loaders = append(c.loaders, l)
+		c.groups = append(c.groups, g)
+	}
+
+	t.Cleanup(func() {
+		for i := range c.servers {
+			c.servers[i].Close()
+			c.groups[i].Close()
+		}
+	})
+
+	return c
+}
+
+func (c *cluster) loads() int32 {
+	var n int32
+	for _, l := range c.loaders {
+		n += atomic.LoadInt32(&l.loads)
+	}
+	return n
+}
+
+func TestGroup(t *testing.T) {
+	c := newCluster(t, 3)
+
+	for k := 0; k < 30; k++ {
+		key := strconv.Itoa(k)
+		for _, g := range c.groups {
+			v, err := g.CFetch(nil, key)
+			if err != nil {
+				t.Fatalf("Gets %v, wants nil", err)
+			}
+			if s := string(v.([]byte)); s != "value of "+key {
+				t.Fatalf(`Gets %q, wants "value of %s"`, s, key)
+			}
+		}
+	}
+
+	if n := c.loads(); n != 30 {
+		t.Fatalf("Gets %d loads, wants 30 (once for each key)", n)
+	}
+
+	_, err := c.groups[0].CFetch(nil, 1)
+	if err != ErrNotStringKey {
+		t.Fatalf("Gets %v, wants ErrNotStringKey", err)
+	}
+}
+
+func TestGroupLoaderError(t *testing.T) {
+	c := newCluster(t, 2)
+
+	for _, g := range c.groups {
+		_, err := g.CFetch(nil, "broken")
+		if err == nil {
+			t.Fatal("Gets nil, wants an error")
+		}
+	}
+
+	if n := c.loads(); n != 2 {
+		t.Fatalf("Gets %d loads, wants 2 (errors aren't cached)", n)
+	}
+}
+
+func TestGroupPeerDown(t *testing.T) {
+	c := newCluster(t, 2)
+	down := c.servers[1].URL
+
+	var key string
+	for k := 0; ; k++ {
+		key = strconv.Itoa(k)
+		if c.groups[0].Owner(key) == down {
+			break
+		}
+	}
+
+	c.servers[1].Close()
+	v, err := c.groups[0].CFetch(nil, key)
+	if err != nil {
+		t.Fatalf("Gets %v, wants nil (loaded locally)", err)
+	}
+	if s := string(v.([]byte)); s != "value of "+key {
+		t.Fatalf(`Gets %q, wants "value of %s"`, s, key)
+	}
+	if n := atomic.LoadInt32(&c.loaders[0].loads); n != 1 {
+		t.Fatalf("Gets %d local loads, wants 1", n)
+	}
+}
+
+func TestGroupHotKeys(t *testing.T) {
+	c := newCluster(t, 2, SetHotKeys(2, time.Minute, time.Minute))
+	remote := c.servers[1].URL
+
+	var key string
+	for k := 0; ; k++ {
+		key = strconv.Itoa(k)
+		if c.groups[0].Owner(key) == remote {
+			break
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.groups[0].CFetch(nil, key); err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+	}
+
+	// 2 requests before the key gets hot, and 1 request to replicate it
+	if n := atomic.LoadInt32(&c.served); n != 3 {
+		t.Fatalf("Gets %d requests to the owner, wants 3", n)
+	}
+}
+
+func TestGroupSharesRequests(t *testing.T) {
+	c := newCluster(t, 2)
+	g := c.groups[0]
+	if g.Owner("slow") == c.servers[0].URL {
+		g = c.groups[1]
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.CFetch(nil, "slow"); err != nil {
+				t.Errorf("Gets %v, wants nil", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&c.served); n != 1 {
+		t.Fatalf("Gets %d requests to the owner, wants 1", n)
+	}
+}
+
+func TestGroupPeerStatus(t *testing.T) {
+	fallbacks := map[int]bool{
+		http.StatusNotFound:           false,
+		http.StatusForbidden:          false,
+		http.StatusBadGateway:         true,
+		http.StatusServiceUnavailable: true,
+	}
+
+	for code, fallback := range fallbacks {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		defer s.Close()
+
+		l := &countLoader{}
+		self := "http://self.invalid"
+		g := NewGroup("test", self, []string{self, s.URL}, fetchmgr.AsCFetcher{Fetcher: l})
+		defer g.Close()
+
+		var key string
+		for k := 0; ; k++ {
+			key = strconv.Itoa(k)
+			if g.Owner(key) == s.URL {
+				break
+			}
+		}
+
+		_, err := g.CFetch(nil, key)
+		loads := atomic.LoadInt32(&l.loads)
+		var pe PeerError
+		if fallback && (err != nil || loads != 1) {
+			t.Fatalf("Gets (%v, %d loads) for %d, wants a local load", err, loads, code)
+		}
+		if !fallback && !errors.As(err, &pe) {
+			t.Fatalf("Gets %v for %d, wants PeerError", err, code)
+		}
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	type point struct{ X, Y int }
+	codec := JSONCodec{New: func() interface{} { return &point{} }}
+
+	b, err := codec.Encode(point{1, 2})
+	if err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+	v, err := codec.Decode(b)
+	if err != nil || v != (point{1, 2}) {
+		t.Fatalf("Gets (%v, %v), wants ({1 2}, nil)", v, err)
+	}
+}
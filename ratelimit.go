@@ -0,0 +1,185 @@
+package fetchmgr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited means the CFetch call was rejected because there were no
+// tokens left in the bucket
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitedCFetcher limits the rate of calls to the internal CFetcher by the
+// token bucket algorithm.
+type RateLimitedCFetcher struct {
+	wrapped
+	rate   float64
+	burst  float64
+	nowait bool
+	clock  Clock
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+type rateLimitSetting struct {
+	clock Clock
+}
+
+// RateLimitSetting makes arguments for NewRateLimitedCFetcher constracter
+type RateLimitSetting func(*rateLimitSetting)
+
+// SetRateLimitClock sets the clock to add tokens and to wait for them
+func SetRateLimitClock(c Clock) RateLimitSetting {
+	return func(rs *rateLimitSetting) {
+		rs.clock = c
+	}
+}
+
+// NewRateLimitedCFetcher creates RateLimitedCFetcher. rate is the number of
+// tokens added to the bucket per second and burst is the size of the bucket.
+// CFetch calls wait for a token until their cancel chan is closed. It panics
+// if rate isn't positive.
+func NewRateLimitedCFetcher(
+	fetcher CFetcher,
+	rate float64,
+	burst int,
+	ss ...RateLimitSetting,
+) *RateLimitedCFetcher {
+	return newRateLimitedCFetcher(fetcher, rate, burst, false, ss)
+}
+
+// NewNonBlockingRateLimitedCFetcher creates RateLimitedCFetcher which never
+// waits for tokens. CFetch calls return ErrRateLimited immediately when the
+// bucket is empty. It panics if rate isn't positive.
+func NewNonBlockingRateLimitedCFetcher(
+	fetcher CFetcher,
+	rate float64,
+	burst int,
+	ss ...RateLimitSetting,
+) *RateLimitedCFetcher {
+	return newRateLimitedCFetcher(fetcher, rate, burst, true, ss)
+}
+
+func newRateLimitedCFetcher(
+	fetcher CFetcher,
+	rate float64,
+	burst int,
+	nowait bool,
+	ss []RateLimitSetting,
+) *RateLimitedCFetcher {
+	if !(rate > 0) {
+		panic(fmt.Sprintf("fetchmgr: rate %v isn't positive", rate))
+	}
+
+	setting := &rateLimitSetting{clock: systemClock{}}
+	for _, set := range ss {
+		set(setting)
+	}
+
+	return &RateLimitedCFetcher{
+		wrapped: wrapped{fetcher},
+		rate:    rate,
+		burst:   float64(burst),
+		nowait:  nowait,
+		clock:   setting.clock,
+		tokens:  float64(burst),
+		last:    setting.clock.Now(),
+	}
+}
+
+// CFetch takes a token and calls the internal CFetcher
+func (rf *RateLimitedCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	if err := takeToken(rf, cancel); err != nil {
+		return nil, err
+	}
+	return rf.fetcher.CFetch(cancel, key)
+}
+
+// Revalidate takes a token and revalidates the old value by the internal
+// CFetcher
+func (rf *RateLimitedCFetcher) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	if err := takeToken(rf, cancel); err != nil {
+		return nil, false, err
+	}
+	return revalidate(rf.fetcher, cancel, key, old)
+}
+
+// takeToken takes a token from the bucket, waiting for it if necessary
+func takeToken(rf *RateLimitedCFetcher, cancel <-chan struct{}) error {
+	wait, ok := reserveToken(rf, rf.clock.Now())
+	if !ok {
+		return ErrRateLimited
+	}
+
+	if wait > 0 {
+		ready := make(chan struct{})
+		t := rf.clock.AfterFunc(wait, func() { close(ready) })
+		select {
+		case <-cancel:
+			t.Stop()
+			releaseToken(rf)
+			return ErrFetchCanceled
+		case <-ready:
+		}
+	}
+
+	return nil
+}
+
+// Close closes the internal CFetcher if it is an io.Closer
+func (rf *RateLimitedCFetcher) Close() error {
+	fc, ok := rf.fetcher.(io.Closer)
+	if ok {
+		return fc.Close()
+	}
+
+	return nil
+}
+
+// reserveToken takes a token from the bucket and returns the duration to wait
+// until the token is actually available.
+func reserveToken(rf *RateLimitedCFetcher, now time.Time) (time.Duration, bool) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if elapsed := now.Sub(rf.last); elapsed > 0 {
+		rf.tokens += elapsed.Seconds() * rf.rate
+		if rf.tokens > rf.burst {
+			rf.tokens = rf.burst
+		}
+		rf.last = now
+	}
+
+	if rf.tokens >= 1 {
+		rf.tokens--
+		return 0, true
+	}
+
+	if rf.nowait {
+		return 0, false
+	}
+
+	// Borrow the token from the future
+	rf.tokens--
+	wait := time.Duration(-rf.tokens / rf.rate * float64(time.Second))
+	return wait, true
+}
+
+// releaseToken gives back the token reserved by the canceled call
+func releaseToken(rf *RateLimitedCFetcher) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	rf.tokens++
+	if rf.tokens > rf.burst {
+		rf.tokens = rf.burst
+	}
+}
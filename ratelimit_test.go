@@ -0,0 +1,124 @@
+package fetchmgr_test
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+type countCFetcher int32
+
+func (cnt *countCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	atomic.AddInt32((*int32)(cnt), 1)
+	return key, nil
+}
+
+// waitCount waits until cnt reaches n
+func waitCount(t *testing.T, cnt *countCFetcher, n int32) {
+	t.Helper()
+	for i := 0; atomic.LoadInt32((*int32)(cnt)) != n; i++ {
+		if i >= 1000 {
+			t.Fatalf("Gets %d calls, wants %d", atomic.LoadInt32((*int32)(cnt)), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRateLimitedCFetcher(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	var cnt countCFetcher
+	rf := NewRateLimitedCFetcher(&cnt, 100, 2, SetRateLimitClock(clk))
+	defer rf.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 4; i++ {
+			v, err := rf.CFetch(nil, i)
+			if err != nil || v != i {
+				t.Errorf("Gets (%v, %v), wants (%d, nil)", v, err, i)
+			}
+		}
+	}()
+
+	// 2 tokens are in the bucket and a token is added every 10ms
+	waitCount(t, &cnt, 2)
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32((*int32)(&cnt)); n != 2 {
+		t.Fatalf("Gets %d calls, wants 2 before the clock advances", n)
+	}
+	clk.Advance(10 * time.Millisecond)
+	waitCount(t, &cnt, 3)
+	clk.Advance(10 * time.Millisecond)
+	waitCount(t, &cnt, 4)
+	<-done
+}
+
+func TestRateLimitedCFetcherCancel(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	var cnt countCFetcher
+	rf := NewRateLimitedCFetcher(&cnt, 0.1, 1, SetRateLimitClock(clk))
+	defer rf.Close()
+
+	if _, err := rf.CFetch(nil, 1); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	v, err := rf.CFetch(cancel, 2)
+	if err != ErrFetchCanceled {
+		t.Fatalf("Gets (%v, %v), wants ErrFetchCanceled", v, err)
+	}
+
+	// The canceled call gives back its token
+	clk.Advance(10 * time.Second)
+	if _, err := rf.CFetch(nil, 3); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+	if n := atomic.LoadInt32((*int32)(&cnt)); n != 2 {
+		t.Fatalf("Gets %d calls, wants 2", n)
+	}
+}
+
+func TestNonBlockingRateLimitedCFetcher(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	var cnt countCFetcher
+	rf := NewNonBlockingRateLimitedCFetcher(&cnt, 0.1, 2, SetRateLimitClock(clk))
+	defer rf.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := rf.CFetch(nil, i); err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+	}
+
+	v, err := rf.CFetch(nil, 3)
+	if err != ErrRateLimited {
+		t.Fatalf("Gets (%v, %v), wants ErrRateLimited", v, err)
+	}
+
+	clk.Advance(10 * time.Second)
+	if _, err := rf.CFetch(nil, 4); err != nil {
+		t.Fatalf("Gets %v, wants nil after a token is added", err)
+	}
+	if n := atomic.LoadInt32((*int32)(&cnt)); n != 3 {
+		t.Fatalf("Gets %d calls, wants 3", n)
+	}
+}
+
+func TestRateLimitedCFetcherInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Gets no panic for %v, wants a panic", rate)
+				}
+			}()
+			NewRateLimitedCFetcher(new(countCFetcher), rate, 1)
+		}()
+	}
+}
@@ -0,0 +1,64 @@
+package fetchmgr
+
+// revalidatable is implemented by wrappers which have the Revalidate method
+// but can revalidate values only if their internal fetchers can
+type revalidatable interface {
+	canRevalidate() bool
+}
+
+// wrapped holds the internal fetcher of a wrapper. Wrappers which embed it
+// can revalidate values only if the internal fetcher can.
+type wrapped struct {
+	fetcher CFetcher
+}
+
+func (w wrapped) canRevalidate() bool {
+	_, ok := RevalidatorOf(w.fetcher)
+	return ok
+}
+
+// RevalidatorOf returns f as Revalidator if f can revalidate values. Wrappers
+// in this package have the Revalidate method even if their internal fetchers
+// can't revalidate, so use it instead of a type assertion.
+func RevalidatorOf(f CFetcher) (Revalidator, bool) {
+	r, ok := f.(Revalidator)
+	if !ok {
+		return nil, false
+	}
+	if rv, ok := f.(revalidatable); ok && !rv.canRevalidate() {
+		return nil, false
+	}
+	return r, true
+}
+
+// revalidate calls Revalidate of f if f can revalidate values. Otherwise, it
+// fetches the new value.
+func revalidate(
+	f CFetcher,
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	if r, ok := RevalidatorOf(f); ok {
+		return r.Revalidate(cancel, key, old)
+	}
+	v, err := f.CFetch(cancel, key)
+	return v, true, err
+}
+
+// fetchCall is a CFetch or Revalidate call which wrappers make. changed is
+// always true for CFetch.
+type fetchCall func(cancel <-chan struct{}) (value interface{}, changed bool, err error)
+
+func cfetchCall(f CFetcher, key interface{}) fetchCall {
+	return func(cancel <-chan struct{}) (interface{}, bool, error) {
+		v, err := f.CFetch(cancel, key)
+		return v, true, err
+	}
+}
+
+func revalidateCall(f CFetcher, key interface{}, old interface{}) fetchCall {
+	return func(cancel <-chan struct{}) (interface{}, bool, error) {
+		return revalidate(f, cancel, key, old)
+	}
+}
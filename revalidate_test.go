@@ -0,0 +1,103 @@
+package fetchmgr_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+type ctxVersionedFetcher struct {
+	*versionedFetcher
+}
+
+func (cf ctxVersionedFetcher) Fetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return cf.versionedFetcher.Fetch(key)
+}
+
+func (cf ctxVersionedFetcher) Revalidate(
+	ctx context.Context,
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	return cf.versionedFetcher.Revalidate(key, old)
+}
+
+// ctxOnlyFetcher hides Revalidate of versionedFetcher
+type ctxOnlyFetcher struct {
+	vf *versionedFetcher
+}
+
+func (cf ctxOnlyFetcher) Fetch(ctx context.Context, key interface{}) (interface{}, error) {
+	return cf.vf.Fetch(key)
+}
+
+func TestWrappersRevalidate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	wrappers := map[string]func(CFetcher) CFetcher{
+		"Limited":     func(f CFetcher) CFetcher { return NewLimitedCFetcher(f, 1) },
+		"Safe":        func(f CFetcher) CFetcher { return NewSafeCFetcher(f) },
+		"KeySafe":     func(f CFetcher) CFetcher { return NewKeySafeCFetcher(f) },
+		"RateLimited": func(f CFetcher) CFetcher { return NewRateLimitedCFetcher(f, 1000, 10) },
+		"Chaos":       func(f CFetcher) CFetcher { return NewChaosCFetcher(f, 1) },
+		"Logging":     func(f CFetcher) CFetcher { return NewLoggingCFetcher(f, logger) },
+		"Hedged":      func(f CFetcher) CFetcher { return NewHedgedCFetcher(f, time.Hour, 0) },
+		"Fallback":    func(f CFetcher) CFetcher { return NewFallbackCFetcher(f) },
+	}
+
+	for name, wrap := range wrappers {
+		t.Run(name, func(t *testing.T) {
+			vf := &versionedFetcher{}
+			testWrappedRevalidator(t, vf, wrap(AsCRevalidator{FetchRevalidator: vf}), 1)
+
+			vf = &versionedFetcher{}
+			testWrappedRevalidator(t, vf, wrap(AsCFetcher{Fetcher: vf}), 0)
+
+			if _, ok := RevalidatorOf(wrap(AsCFetcher{Fetcher: vf})); ok {
+				t.Fatal("Gets a Revalidator, wants none for a plain Fetcher")
+			}
+		})
+	}
+
+	t.Run("FromCtxFetcher", func(t *testing.T) {
+		vf := &versionedFetcher{}
+		testWrappedRevalidator(t, vf, FromCtxFetcher{CtxFetcher: ctxVersionedFetcher{vf}}, 1)
+
+		vf = &versionedFetcher{}
+		testWrappedRevalidator(t, vf, FromCtxFetcher{CtxFetcher: ctxOnlyFetcher{vf}}, 0)
+	})
+}
+
+// testWrappedRevalidator fetches an expired key through f. It's revalidated
+// if revalidations is 1, or fetched again if it's 0.
+func testWrappedRevalidator(t *testing.T, vf *versionedFetcher, f CFetcher, revalidations int32) {
+	t.Helper()
+
+	clk := NewFakeClock(time.Now())
+	cached := CNew(
+		f,
+		SetBucketNum(1),
+		SetClock(clk),
+		SetTTL(time.Minute),
+		SetStaleTTL(time.Hour),
+	)
+	defer cached.Close()
+
+	first, _ := cached.CFetch(nil, "doc")
+	clk.Advance(2 * time.Minute)
+	second, _ := cached.CFetch(nil, "doc")
+
+	if n := atomic.LoadInt32(&vf.revalidation); n != revalidations {
+		t.Fatalf("Gets %d revalidations, wants %d", n, revalidations)
+	}
+	if n, want := atomic.LoadInt32(&vf.fetches), 2-revalidations; n != want {
+		t.Fatalf("Gets %d fetches, wants %d", n, want)
+	}
+	if revalidations == 1 && first != second {
+		t.Fatalf("Gets %v, wants the old value %v", second, first)
+	}
+}
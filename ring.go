@@ -0,0 +1,181 @@
+package fetchmgr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is the number of virtual nodes for each fetcher of
+// RingCFetcher with weight 1
+const DefaultVirtualNodes = 100
+
+// ErrNoFetchers means there are no fetchers in RingCFetcher or
+// FallbackCFetcher
+var ErrNoFetchers = errors.New("no fetchers")
+
+// RingCFetcher holds multiple fetchers and scatters tasks by the consistent
+// hashing. When a fetcher is added or removed, only about 1/N keys move to
+// other fetchers.
+type RingCFetcher struct {
+	vnodes   int
+	hash     func(interface{}) uint
+	mutex    sync.RWMutex
+	points   []ringPoint
+	fetchers map[string]CFetcher
+}
+
+type ringPoint struct {
+	hash uint
+	name string
+}
+
+// NewRingCFetcher creates the instance. vnodes is the number of virtual nodes
+// for each fetcher with weight 1. If vnodes is 0, DefaultVirtualNodes is
+// used.
+func NewRingCFetcher(vnodes int) *RingCFetcher {
+	return NewRingCFetcherWithHash(vnodes, StableHash)
+}
+
+// NewRingCFetcherWithHash creates RingCFetcher which scatters tasks by
+// hashFunc instead of StableHash. StableHash is used if hashFunc is nil.
+func NewRingCFetcherWithHash(
+	vnodes int,
+	hashFunc func(interface{}) uint,
+) *RingCFetcher {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	if hashFunc == nil {
+		hashFunc = StableHash
+	}
+
+	return &RingCFetcher{
+		vnodes:   vnodes,
+		hash:     hashFunc,
+		fetchers: make(map[string]CFetcher),
+	}
+}
+
+// Add adds f to the ring. name identifies f and decides positions of
+// virtual nodes. f gets weight times as many keys as a fetcher with weight 1.
+// If there is already a fetcher named name, it is replaced and returned.
+func (rf *RingCFetcher) Add(name string, f CFetcher, weight int) CFetcher {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	old := removeFetcher(rf, name)
+
+	rf.fetchers[name] = f
+	for i := 0; i < rf.vnodes*weight; i++ {
+		h := mixHash(stableHash(name + "#" + strconv.Itoa(i)))
+		rf.points = append(rf.points, ringPoint{h, name})
+	}
+	sort.Slice(rf.points, func(i, j int) bool {
+		return rf.points[i].hash < rf.points[j].hash
+	})
+
+	return old
+}
+
+// Remove removes the fetcher named name from the ring and returns it. It
+// returns nil if there is no such fetcher.
+func (rf *RingCFetcher) Remove(name string) CFetcher {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	return removeFetcher(rf, name)
+}
+
+func removeFetcher(rf *RingCFetcher, name string) CFetcher {
+	f, ok := rf.fetchers[name]
+	if !ok {
+		return nil
+	}
+	delete(rf.fetchers, name)
+
+	points := rf.points[:0]
+	for _, p := range rf.points {
+		if p.name != name {
+			points = append(points, p)
+		}
+	}
+	rf.points = points
+
+	return f
+}
+
+// Pick returns the name of the fetcher which is responsible for key
+func (rf *RingCFetcher) Pick(key interface{}) (string, bool) {
+	rf.mutex.RLock()
+	defer rf.mutex.RUnlock()
+
+	name, _, ok := pickFetcher(rf, key)
+	return name, ok
+}
+
+func pickFetcher(rf *RingCFetcher, key interface{}) (string, CFetcher, bool) {
+	if len(rf.points) == 0 {
+		return "", nil, false
+	}
+
+	h := mixHash(rf.hash(key))
+	i := sort.Search(len(rf.points), func(i int) bool {
+		return rf.points[i].hash >= h
+	})
+	if i == len(rf.points) {
+		i = 0 // Wrap around the ring
+	}
+
+	name := rf.points[i].name
+	return name, rf.fetchers[name], true
+}
+
+// CFetch calls one of internal Fetchers
+func (rf *RingCFetcher) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	rf.mutex.RLock()
+	_, f, ok := pickFetcher(rf, key)
+	rf.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrNoFetchers
+	}
+	return f.CFetch(cancel, key)
+}
+
+// CtxFetch calls one of internal Fetchers with context.Context
+func (rf *RingCFetcher) CtxFetch(ctx context.Context, key interface{}) (interface{}, error) {
+	rf.mutex.RLock()
+	_, f, ok := pickFetcher(rf, key)
+	rf.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrNoFetchers
+	}
+	return ctxFetch(f, ctx, key)
+}
+
+// Close calls Close() for all internal FetchCloser instances
+func (rf *RingCFetcher) Close() error {
+	rf.mutex.RLock()
+	defer rf.mutex.RUnlock()
+
+	var errs []InnerError
+	for _, f := range rf.fetchers {
+		fc, ok := f.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := fc.Close(); err != nil {
+			errs = append(errs, InnerError{f, err})
+		}
+	}
+	if len(errs) > 0 {
+		return InnerErrors(errs)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package fetchmgr_test
+
+import (
+	"io"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func TestRingCFetcher(t *testing.T) {
+	var cnt countCFetcher
+	rf := NewRingCFetcher(0)
+	defer rf.Close()
+
+	v, err := rf.CFetch(nil, 1)
+	if err != ErrNoFetchers {
+		t.Fatalf("Gets (%v, %v), wants ErrNoFetchers", v, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		rf.Add(strconv.Itoa(i), &cnt, 1)
+	}
+
+	keynum := 10000
+	before := make([]string, keynum)
+	counts := make(map[string]int)
+	for k := 0; k < keynum; k++ {
+		before[k], _ = rf.Pick(k)
+		counts[before[k]]++
+	}
+	for name, n := range counts {
+		if n < keynum/8 {
+			t.Fatalf("Gets %d keys for %s, wants about %d", n, name, keynum/4)
+		}
+	}
+
+	rf.Add("4", &cnt, 1)
+	moved := 0
+	for k := 0; k < keynum; k++ {
+		after, _ := rf.Pick(k)
+		if after != before[k] {
+			if after != "4" {
+				t.Fatalf("Key %d moves from %s to %s, wants 4", k, before[k], after)
+			}
+			moved++
+		}
+	}
+	if moved < keynum/10 || moved > keynum*3/10 {
+		t.Fatalf("Gets %d moved keys, wants about %d", moved, keynum/5)
+	}
+
+	if rf.Remove("4") == nil {
+		t.Fatal("Gets nil, wants the removed fetcher")
+	}
+	for k := 0; k < keynum; k++ {
+		if after, _ := rf.Pick(k); after != before[k] {
+			t.Fatalf("Key %d moves from %s to %s after removing", k, before[k], after)
+		}
+	}
+
+	v, err = rf.CFetch(nil, "key")
+	if err != nil || v != "key" {
+		t.Fatalf(`Gets (%v, %v), wants ("key", nil)`, v, err)
+	}
+}
+
+func TestRingCFetcherWeight(t *testing.T) {
+	var cnt countCFetcher
+	rf := NewRingCFetcher(50)
+	rf.Add("light", &cnt, 1)
+	rf.Add("heavy", &cnt, 3)
+
+	heavy := 0
+	for k := 0; k < 10000; k++ {
+		if name, _ := rf.Pick(strconv.Itoa(k)); name == "heavy" {
+			heavy++
+		}
+	}
+	if heavy < 6500 || heavy > 8500 {
+		t.Fatalf("Gets %d keys for heavy, wants about 7500", heavy)
+	}
+}
+
+func TestCNewVirtualNodes(t *testing.T) {
+	cached := New(constFetcher(0), SetVirtualNodes(10))
+	defer cached.Close()
+
+	v, err := cached.Fetch("key")
+	if err != nil || v != "const" {
+		t.Fatalf(`Gets (%v, %v), wants ("const", nil)`, v, err)
+	}
+}
+
+func TestCachedRing(t *testing.T) {
+	cnt := new(countCFetcher)
+	closer := &closingFetcher{}
+	cached := CNew(
+		struct {
+			CFetcher
+			io.Closer
+		}{cnt, closer},
+		SetBucketNum(2),
+		SetVirtualNodes(10),
+	)
+
+	cr, ok := cached.(*CachedRing)
+	if !ok {
+		t.Fatalf("Gets %T, wants *CachedRing", cached)
+	}
+
+	// Move all keys to a new bucket
+	if err := cr.AddBucket("new", 1); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+	for _, name := range []string{"0", "1"} {
+		if err := cr.RemoveBucket(name); err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+	}
+	if name, _ := cr.Pick("key"); name != "new" {
+		t.Fatalf(`Gets %q, wants "new"`, name)
+	}
+
+	for i := 0; i < 2; i++ {
+		v, err := cached.CFetch(nil, "key")
+		if err != nil || v != "key" {
+			t.Fatalf(`Gets (%v, %v), wants ("key", nil)`, v, err)
+		}
+	}
+	if n := atomic.LoadInt32((*int32)(cnt)); n != 1 {
+		t.Fatalf("Gets %d calls, wants 1 (cached by the new bucket)", n)
+	}
+	if n := atomic.LoadInt32(&closer.closes); n != 0 {
+		t.Fatalf("Gets %d closes, wants 0 after removing buckets", n)
+	}
+
+	if err := cached.Close(); err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+	if n := atomic.LoadInt32(&closer.closes); n != 1 {
+		t.Fatalf("Gets %d closes, wants 1", n)
+	}
+	if err := cr.AddBucket("late", 1); err != ErrFetcherClosed {
+		t.Fatalf("Gets %v, wants ErrFetcherClosed", err)
+	}
+
+	plain := CNew(AsCFetcher{Fetcher: constFetcher(0)})
+	defer plain.Close()
+	if _, ok := plain.(*CachedRing); ok {
+		t.Fatalf("Gets *CachedRing, wants none without SetVirtualNodes")
+	}
+}
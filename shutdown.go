@@ -0,0 +1,99 @@
+package fetchmgr
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Shutdowner is a fetcher which can be closed gracefully
+type Shutdowner interface {
+	Shutdown(cancel <-chan struct{}) error
+}
+
+// ErrShutdownCanceled means cancel was closed before in-flight fetches
+// finished
+var ErrShutdownCanceled = errors.New("shutdown canceled before fetches finished")
+
+// Shutdown closes this instance gracefully. It stops fetching missing keys,
+// and waits for in-flight fetches to serve their results to waiters. Then it
+// closes the underlying fetcher. Cached values are served until then.
+// If cancel is closed first, it closes this instance like Close and returns
+// ErrShutdownCanceled.
+func (c *CachedCFetcher) Shutdown(cancel <-chan struct{}) error {
+	c.mutex.Lock()
+	c.draining = true
+	c.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-cancel:
+		err = ErrShutdownCanceled
+	}
+
+	if cerr := c.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Shutdown shuts down all internal fetchers gracefully
+func (bf BucketedCFetcher) Shutdown(cancel <-chan struct{}) error {
+	return shutdownAll(bf, cancel)
+}
+
+// Shutdown shuts down all internal fetchers gracefully
+func (rf *RingCFetcher) Shutdown(cancel <-chan struct{}) error {
+	rf.mutex.RLock()
+	fs := make([]CFetcher, 0, len(rf.fetchers))
+	for _, f := range rf.fetchers {
+		fs = append(fs, f)
+	}
+	rf.mutex.RUnlock()
+
+	return shutdownAll(fs, cancel)
+}
+
+// shutdownAll shuts down fs in parallel because they share the deadline.
+// Use errors.Is to check ErrShutdownCanceled in the returned error.
+func shutdownAll(fs []CFetcher, cancel <-chan struct{}) error {
+	errs := make([]error, len(fs))
+	var wg sync.WaitGroup
+	for i, f := range fs {
+		wg.Add(1)
+		go func(i int, f CFetcher) {
+			defer wg.Done()
+			errs[i] = shutdown(f, cancel)
+		}(i, f)
+	}
+	wg.Wait()
+
+	var ies []InnerError
+	for i, err := range errs {
+		if err != nil {
+			ies = append(ies, InnerError{fs[i], err})
+		}
+	}
+	if len(ies) > 0 {
+		return InnerErrors(ies)
+	}
+
+	return nil
+}
+
+func shutdown(f CFetcher, cancel <-chan struct{}) error {
+	switch ff := f.(type) {
+	case Shutdowner:
+		return ff.Shutdown(cancel)
+	case io.Closer:
+		return ff.Close()
+	}
+	return nil
+}
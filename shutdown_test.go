@@ -0,0 +1,64 @@
+package fetchmgr_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func TestShutdown(t *testing.T) {
+	gf := &gateFetcher{release: make(chan struct{})}
+	ccf := CNew(gf)
+
+	rets := make(chan interface{})
+	go func() {
+		v, _ := ccf.CFetch(nil, "key")
+		rets <- v
+	}()
+	waitCalls(t, gf, 1)
+
+	errs := make(chan error)
+	go func() {
+		errs <- ccf.(Shutdowner).Shutdown(nil)
+	}()
+
+	time.Sleep(10 * time.Millisecond) // Wait for Shutdown to stop misses
+	if _, err := ccf.CFetch(nil, "another key"); err != ErrFetcherClosed {
+		t.Fatalf("Gets %v, wants ErrFetcherClosed", err)
+	}
+
+	close(gf.release)
+	if v := <-rets; v != "key" {
+		t.Fatalf(`Gets %v, wants "key"`, v)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Gets %v, wants nil", err)
+	}
+}
+
+func TestShutdownCanceled(t *testing.T) {
+	gf := &gateFetcher{release: make(chan struct{})}
+	ccf := CNew(gf)
+
+	errs := make(chan error)
+	go func() {
+		_, err := ccf.CFetch(nil, "key")
+		errs <- err
+	}()
+	waitCalls(t, gf, 1)
+
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(cancel)
+	}()
+
+	if err := ccf.(Shutdowner).Shutdown(cancel); !errors.Is(err, ErrShutdownCanceled) {
+		t.Fatalf("Gets %v, wants ErrShutdownCanceled", err)
+	}
+	if err := <-errs; err != ErrFetcherClosed {
+		t.Fatalf("Gets %v, wants ErrFetcherClosed", err)
+	}
+}
@@ -7,8 +7,8 @@ import (
 
 // SafeFetcher is a synced instance of Fetcher
 type safeCFetcher struct {
-	mutex   *sync.Mutex
-	fetcher CFetcher
+	mutex *sync.Mutex
+	wrapped
 }
 
 // NewSafeCFetcher makes f thread-safe. It will be a slow instance because
@@ -19,7 +19,7 @@ func NewSafeCFetcher(f CFetcher) CFetcher {
 
 func newSafeCFetcher(f CFetcher) safeCFetcher {
 	var mutex sync.Mutex
-	return safeCFetcher{&mutex, f}
+	return safeCFetcher{&mutex, wrapped{f}}
 }
 
 // CFetch fetches a value
@@ -29,6 +29,17 @@ func (sf safeCFetcher) CFetch(cancel <-chan struct{}, k interface{}) (interface{
 	return sf.fetcher.CFetch(cancel, k)
 }
 
+// Revalidate revalidates an old value. It's serialized with CFetch.
+func (sf safeCFetcher) Revalidate(
+	cancel <-chan struct{},
+	k interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	sf.mutex.Lock()
+	defer sf.mutex.Unlock()
+	return revalidate(sf.fetcher, cancel, k, old)
+}
+
 // safeCFetchCloser a synced instance of FetchCloser
 type safeCFetchCloser struct {
 	safeCFetcher
@@ -71,3 +82,119 @@ func NewSafeFetchCloser(fc FetchCloser) FetchCloser {
 		io.Closer
 	}{AsFetcher{sfcfc}, sfcfc}
 }
+
+// keySafeCFetcher is an instance of CFetcher which serializes calls per key
+type keySafeCFetcher struct {
+	locks *keyLocks
+	wrapped
+}
+
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[interface{}]*keyLock
+}
+
+type keyLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// NewKeySafeCFetcher makes f thread-safe per key. CFetch() calls for the same
+// key are serialized, but calls for different keys run concurrently.
+func NewKeySafeCFetcher(f CFetcher) CFetcher {
+	return newKeySafeCFetcher(f)
+}
+
+func newKeySafeCFetcher(f CFetcher) keySafeCFetcher {
+	locks := &keyLocks{locks: make(map[interface{}]*keyLock)}
+	return keySafeCFetcher{locks, wrapped{f}}
+}
+
+// CFetch fetches a value
+func (kf keySafeCFetcher) CFetch(cancel <-chan struct{}, k interface{}) (interface{}, error) {
+	v, _, err := keyLockCall(kf, cancel, k, cfetchCall(kf.fetcher, k))
+	return v, err
+}
+
+// Revalidate revalidates an old value. It's serialized with CFetch for the
+// same key.
+func (kf keySafeCFetcher) Revalidate(
+	cancel <-chan struct{},
+	k interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	return keyLockCall(kf, cancel, k, revalidateCall(kf.fetcher, k, old))
+}
+
+func keyLockCall(
+	kf keySafeCFetcher,
+	cancel <-chan struct{},
+	k interface{},
+	call fetchCall,
+) (interface{}, bool, error) {
+	l := acquireKeyLock(kf.locks, k)
+	defer releaseKeyLock(kf.locks, k, l)
+
+	select {
+	case l.ch <- struct{}{}:
+	case <-cancel:
+		return nil, false, ErrFetchCanceled
+	}
+	defer func() { <-l.ch }()
+
+	return call(cancel)
+}
+
+func acquireKeyLock(ls *keyLocks, k interface{}) *keyLock {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	l, ok := ls.locks[k]
+	if !ok {
+		l = &keyLock{ch: make(chan struct{}, 1)}
+		ls.locks[k] = l
+	}
+	l.refs++
+
+	return l
+}
+
+func releaseKeyLock(ls *keyLocks, k interface{}, l *keyLock) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		// Nobody is using the lock for k
+		delete(ls.locks, k)
+	}
+}
+
+// NewKeySafeFetcher makes f thread-safe per key. Fetch() calls for the same
+// key are serialized, but calls for different keys run concurrently.
+func NewKeySafeFetcher(f Fetcher) Fetcher {
+	cf := AsCFetcher{f}
+	kscf := NewKeySafeCFetcher(cf)
+	return AsFetcher{kscf}
+}
+
+// NewKeySafeCFetchCloser makes fc thread-safe per key. Close() isn't
+// serialized with CFetch() calls.
+func NewKeySafeCFetchCloser(fc CFetchCloser) CFetchCloser {
+	return keySafeCFetchCloser{newKeySafeCFetcher(fc), fc}
+}
+
+// keySafeCFetchCloser is a per key synced instance of CFetchCloser
+type keySafeCFetchCloser struct {
+	keySafeCFetcher
+	io.Closer
+}
+
+// NewKeySafeFetchCloser makes fc thread-safe per key. Close() isn't
+// serialized with Fetch() calls.
+func NewKeySafeFetchCloser(fc FetchCloser) FetchCloser {
+	return struct {
+		Fetcher
+		io.Closer
+	}{NewKeySafeFetcher(fc), fc}
+}
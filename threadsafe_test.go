@@ -4,6 +4,7 @@ import (
 	"io"
 	"sync"
 	"testing"
+	"time"
 
 	. "github.com/hiratara/fetchmgr"
 )
@@ -60,3 +61,57 @@ func fetch10000Times(t *testing.T, f Fetcher) {
 	}
 	wg.Wait()
 }
+
+type perKeyFetcher struct {
+	mutex   sync.Mutex
+	running map[interface{}]int
+	total   int
+	overlap bool
+	maxAll  int
+}
+
+func (f *perKeyFetcher) Fetch(key interface{}) (interface{}, error) {
+	f.mutex.Lock()
+	f.running[key]++
+	f.total++
+	if f.running[key] > 1 {
+		f.overlap = true
+	}
+	if f.total > f.maxAll {
+		f.maxAll = f.total
+	}
+	f.mutex.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	f.mutex.Lock()
+	f.running[key]--
+	f.total--
+	f.mutex.Unlock()
+	return key, nil
+}
+
+func TestKeySafeFetcher(t *testing.T) {
+	f := &perKeyFetcher{running: make(map[interface{}]int)}
+	kf := NewKeySafeFetcher(f)
+
+	var wg sync.WaitGroup
+	wg.Add(20)
+	for i := 0; i < 20; i++ {
+		key := i % 4
+		go func() {
+			for j := 0; j < 10; j++ {
+				_, _ = kf.Fetch(key)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if f.overlap {
+		t.Fatal("Calls for the same key overlapped")
+	}
+	if f.maxAll < 2 {
+		t.Fatalf("Gets %d concurrent calls, wants more than 1", f.maxAll)
+	}
+}
@@ -0,0 +1,32 @@
+package typedfetchmgr
+
+import (
+	"github.com/hiratara/fetchmgr"
+)
+
+// NewBucketedCFetcher makes CFetcher which scatters tasks over fs by hash
+// values of keys. Close() closes fs which are io.Closer.
+func NewBucketedCFetcher[K comparable, V any](fs []CFetcher[K, V]) CFetchCloser[K, V] {
+	return Typed[K, V](fetchmgr.NewBucketedCFetcher(untypedAll(fs)))
+}
+
+// NewBucketedCFetcherWithHash is NewBucketedCFetcher which scatters tasks by
+// hashFunc. The default hash function is used if hashFunc is nil.
+func NewBucketedCFetcherWithHash[K comparable, V any](
+	fs []CFetcher[K, V],
+	hashFunc func(K) uint,
+) CFetchCloser[K, V] {
+	var h func(interface{}) uint
+	if hashFunc != nil {
+		h = func(key interface{}) uint { return hashFunc(key.(K)) }
+	}
+	return Typed[K, V](fetchmgr.NewBucketedCFetcherWithHash(untypedAll(fs), h))
+}
+
+func untypedAll[K comparable, V any](fs []CFetcher[K, V]) []fetchmgr.CFetcher {
+	ufs := make([]fetchmgr.CFetcher, len(fs))
+	for i, f := range fs {
+		ufs[i] = Untyped(f)
+	}
+	return ufs
+}
@@ -0,0 +1,34 @@
+package typedfetchmgr
+
+import (
+	"io"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// NewCachedCFetcher makes CFetcher which memoizes the results of fetcher for
+// ttl. See fetchmgr.NewCachedCFetcher.
+func NewCachedCFetcher[K comparable, V any](
+	fetcher CFetcher[K, V],
+	ttl time.Duration,
+	interval time.Duration,
+) CFetchCloser[K, V] {
+	cached := fetchmgr.NewCachedCFetcher(Untyped(fetcher), ttl, interval)
+	return Typed[K, V](cached)
+}
+
+// NewCachedFetcher makes Fetcher which memoizes the results of fetcher for
+// ttl. See fetchmgr.NewCachedFetcher.
+func NewCachedFetcher[K comparable, V any](
+	fetcher Fetcher[K, V],
+	ttl time.Duration,
+	interval time.Duration,
+) FetchCloser[K, V] {
+	cfetcher := AsCFetcher[K, V]{fetcher}
+	ccfetcher := NewCachedCFetcher[K, V](cfetcher, ttl, interval)
+	return struct {
+		Fetcher[K, V]
+		io.Closer
+	}{AsFetcher[K, V]{ccfetcher}, ccfetcher}
+}
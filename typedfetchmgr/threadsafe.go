@@ -0,0 +1,50 @@
+package typedfetchmgr
+
+import (
+	"io"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// NewSafeCFetcher makes f thread-safe. It will be a slow instance because
+// all CFetch() calls are serialized.
+func NewSafeCFetcher[K comparable, V any](f CFetcher[K, V]) CFetcher[K, V] {
+	return Typed[K, V](fetchmgr.NewSafeCFetcher(Untyped(f)))
+}
+
+// NewSafeCFetchCloser makes fc thread-safe. It will be a slow instance
+// because all CFetch() and Close() calls are serialized.
+func NewSafeCFetchCloser[K comparable, V any](fc CFetchCloser[K, V]) CFetchCloser[K, V] {
+	return Typed[K, V](fetchmgr.NewSafeCFetchCloser(Untyped(fc)))
+}
+
+// NewSafeFetcher makes f thread-safe. It will be a slow instance because
+// all Fetch() calls are serialized.
+func NewSafeFetcher[K comparable, V any](f Fetcher[K, V]) Fetcher[K, V] {
+	cf := AsCFetcher[K, V]{f}
+	return AsFetcher[K, V]{NewSafeCFetcher[K, V](cf)}
+}
+
+// NewSafeFetchCloser makes fc thread-safe. It will be a slow instance
+// because all Fetch() and Close() calls are serialized.
+func NewSafeFetchCloser[K comparable, V any](fc FetchCloser[K, V]) FetchCloser[K, V] {
+	cfc := struct {
+		CFetcher[K, V]
+		io.Closer
+	}{AsCFetcher[K, V]{fc}, fc}
+	sfcfc := NewSafeCFetchCloser[K, V](cfc)
+	return struct {
+		Fetcher[K, V]
+		io.Closer
+	}{AsFetcher[K, V]{sfcfc}, sfcfc}
+}
+
+// NewLimitedCFetcher makes f run at most n CFetch() calls concurrently.
+func NewLimitedCFetcher[K comparable, V any](f CFetcher[K, V], n int) CFetcher[K, V] {
+	return Typed[K, V](fetchmgr.NewLimitedCFetcher(Untyped(f), n))
+}
+
+// NewKeySafeCFetcher makes f thread-safe per key.
+func NewKeySafeCFetcher[K comparable, V any](f CFetcher[K, V]) CFetcher[K, V] {
+	return Typed[K, V](fetchmgr.NewKeySafeCFetcher(Untyped(f)))
+}
@@ -0,0 +1,241 @@
+// Package typedfetchmgr provides type-safe fetchmgr
+package typedfetchmgr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hiratara/fetchmgr"
+)
+
+// CFetcher is the interface in order to fetch outer resources
+// It also provides a cancel chan to cancel fetching.
+type CFetcher[K comparable, V any] interface {
+	CFetch(<-chan struct{}, K) (V, error)
+}
+
+// CFetchCloser has CFetch and Close method
+type CFetchCloser[K comparable, V any] interface {
+	CFetcher[K, V]
+	io.Closer
+}
+
+// Fetcher is the interface in order to fetch outer resources
+type Fetcher[K comparable, V any] interface {
+	Fetch(K) (V, error)
+}
+
+// FetchCloser has Fetch and Close method
+type FetchCloser[K comparable, V any] interface {
+	Fetcher[K, V]
+	io.Closer
+}
+
+// Revalidator is a CFetcher which can check whether an expired value is
+// still valid. See fetchmgr.Revalidator.
+type Revalidator[K comparable, V any] interface {
+	CFetcher[K, V]
+	Revalidate(cancel <-chan struct{}, key K, old V) (value V, changed bool, err error)
+}
+
+// Hashable is the constraint for keys which have their own hash values.
+// Other keys are hashed by their types and values, which may use reflection.
+type Hashable interface {
+	comparable
+	fetchmgr.Hasher
+}
+
+// ErrUnexpectedType means an untyped fetcher returned or received a value of
+// an unexpected type
+var ErrUnexpectedType = errors.New("unexpected type")
+
+// AsCFetcher makes CFetcher from Fetcher. You will never cancel CFetch call of
+// this type
+type AsCFetcher[K comparable, V any] struct {
+	Fetcher[K, V]
+}
+
+// CFetch fetches values
+func (tf AsCFetcher[K, V]) CFetch(cancel <-chan struct{}, key K) (V, error) {
+	return tf.Fetch(key)
+}
+
+// AsFetcher makes Fetcher from CFetcher.
+type AsFetcher[K comparable, V any] struct {
+	CFetcher[K, V]
+}
+
+// Fetch fetches values
+func (tf AsFetcher[K, V]) Fetch(key K) (V, error) {
+	return tf.CFetch(nil, key)
+}
+
+// FuncFetcher makes new Fetcher from a function
+type FuncFetcher[K comparable, V any] func(K) (V, error)
+
+// Fetch calls the internal function
+func (f FuncFetcher[K, V]) Fetch(k K) (V, error) {
+	return f(k)
+}
+
+// untypedCFetcher is fetchmgr.CFetcher made from CFetcher
+type untypedCFetcher[K comparable, V any] struct {
+	fetcher CFetcher[K, V]
+}
+
+// Untyped makes fetchmgr.CFetcher from CFetcher. It returns ErrUnexpectedType
+// for keys which aren't K. Close() closes f if f is an io.Closer.
+// If f is Revalidator, the result is fetchmgr.Revalidator.
+func Untyped[K comparable, V any](f CFetcher[K, V]) fetchmgr.CFetchCloser {
+	if r, ok := f.(Revalidator[K, V]); ok {
+		return untypedRevalidator[K, V]{untypedCFetcher[K, V]{f}, r}
+	}
+	return untypedCFetcher[K, V]{f}
+}
+
+// CFetch fetches values
+func (uf untypedCFetcher[K, V]) CFetch(cancel <-chan struct{}, key interface{}) (interface{}, error) {
+	k, ok := key.(K)
+	if !ok {
+		return nil, fmt.Errorf("%w: key %v", ErrUnexpectedType, key)
+	}
+	return uf.fetcher.CFetch(cancel, k)
+}
+
+// Close closes the internal fetcher
+func (uf untypedCFetcher[K, V]) Close() error {
+	return closeIfCloser(uf.fetcher)
+}
+
+// untypedRevalidator is fetchmgr.Revalidator made from Revalidator
+type untypedRevalidator[K comparable, V any] struct {
+	untypedCFetcher[K, V]
+	revalidator Revalidator[K, V]
+}
+
+// Revalidate revalidates old values
+func (ur untypedRevalidator[K, V]) Revalidate(
+	cancel <-chan struct{},
+	key interface{},
+	old interface{},
+) (interface{}, bool, error) {
+	k, ok := key.(K)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: key %v", ErrUnexpectedType, key)
+	}
+	o, ok := old.(V)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: value %v", ErrUnexpectedType, old)
+	}
+	return ur.revalidator.Revalidate(cancel, k, o)
+}
+
+// typedCFetcher is CFetcher made from fetchmgr.CFetcher
+type typedCFetcher[K comparable, V any] struct {
+	fetcher fetchmgr.CFetcher
+}
+
+// Typed makes CFetcher from fetchmgr.CFetcher. It returns ErrUnexpectedType
+// for values which aren't V. Close() closes f if f is an io.Closer.
+// If f can revalidate values, the result is Revalidator.
+func Typed[K comparable, V any](f fetchmgr.CFetcher) CFetchCloser[K, V] {
+	if r, ok := fetchmgr.RevalidatorOf(f); ok {
+		return typedRevalidator[K, V]{typedCFetcher[K, V]{f}, r}
+	}
+	return typedCFetcher[K, V]{f}
+}
+
+// CFetch fetches values
+func (tf typedCFetcher[K, V]) CFetch(cancel <-chan struct{}, key K) (V, error) {
+	v, err := tf.fetcher.CFetch(cancel, key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return typedValue[V](v)
+}
+
+func typedValue[V any](v interface{}) (V, error) {
+	var zero V
+	if v == nil {
+		return zero, nil
+	}
+
+	vv, ok := v.(V)
+	if !ok {
+		return zero, fmt.Errorf("%w: value %v", ErrUnexpectedType, v)
+	}
+	return vv, nil
+}
+
+// Close closes the internal fetcher
+func (tf typedCFetcher[K, V]) Close() error {
+	return closeIfCloser(tf.fetcher)
+}
+
+// typedRevalidator is Revalidator made from fetchmgr.Revalidator
+type typedRevalidator[K comparable, V any] struct {
+	typedCFetcher[K, V]
+	revalidator fetchmgr.Revalidator
+}
+
+// Revalidate revalidates old values
+func (tr typedRevalidator[K, V]) Revalidate(cancel <-chan struct{}, key K, old V) (V, bool, error) {
+	v, changed, err := tr.revalidator.Revalidate(cancel, key, old)
+	if err != nil {
+		var zero V
+		return zero, false, err
+	}
+	vv, err := typedValue[V](v)
+	return vv, changed, err
+}
+
+func closeIfCloser(f interface{}) error {
+	fc, ok := f.(io.Closer)
+	if ok {
+		return fc.Close()
+	}
+
+	return nil
+}
+
+// CNew wraps the fetcher and memoizes the results for Fetch
+func CNew[K comparable, V any](
+	fetcher CFetcher[K, V],
+	ss ...fetchmgr.Setting,
+) CFetchCloser[K, V] {
+	cached := fetchmgr.CNew(Untyped(fetcher), ss...)
+	return Typed[K, V](cached)
+}
+
+// New wraps the fetcher and memoizes the results for Fetch
+func New[K comparable, V any](
+	fetcher Fetcher[K, V],
+	ss ...fetchmgr.Setting,
+) FetchCloser[K, V] {
+	cfetcher := AsCFetcher[K, V]{fetcher}
+	ccfetcher := CNew[K, V](cfetcher, ss...)
+	return struct {
+		Fetcher[K, V]
+		io.Closer
+	}{AsFetcher[K, V]{ccfetcher}, ccfetcher}
+}
+
+// CNewHashed is CNew which requires Hashable keys. Keys are scattered over
+// buckets by their Hash method.
+func CNewHashed[K Hashable, V any](
+	fetcher CFetcher[K, V],
+	ss ...fetchmgr.Setting,
+) CFetchCloser[K, V] {
+	return CNew(fetcher, ss...)
+}
+
+// NewHashed is New which requires Hashable keys. Keys are scattered over
+// buckets by their Hash method.
+func NewHashed[K Hashable, V any](
+	fetcher Fetcher[K, V],
+	ss ...fetchmgr.Setting,
+) FetchCloser[K, V] {
+	return New(fetcher, ss...)
+}
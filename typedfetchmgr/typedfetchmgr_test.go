@@ -0,0 +1,216 @@
+package typedfetchmgr_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hiratara/fetchmgr"
+	. "github.com/hiratara/fetchmgr/typedfetchmgr"
+)
+
+type userID int
+
+func TestNew(t *testing.T) {
+	var cnt int32
+	fetcher := FuncFetcher[userID, string](func(id userID) (string, error) {
+		atomic.AddInt32(&cnt, 1)
+		if id == 0 {
+			return "", errors.New("no such user")
+		}
+		return "user" + string(rune('0'+id)), nil
+	})
+	cached := New[userID, string](
+		fetcher,
+		fetchmgr.SetInterval(time.Millisecond),
+		fetchmgr.SetTTL(time.Minute),
+	)
+	defer cached.Close()
+
+	for i := 0; i < 2; i++ {
+		name, err := cached.Fetch(1)
+		if err != nil || name != "user1" {
+			t.Fatalf(`Gets (%v, %v), wants ("user1", nil)`, name, err)
+		}
+	}
+	if n := atomic.LoadInt32(&cnt); n != 1 {
+		t.Fatalf("Gets %d calls, wants 1", n)
+	}
+
+	name, err := cached.Fetch(0)
+	if err == nil || name != "" {
+		t.Fatalf(`Gets (%v, %v), wants ("", error)`, name, err)
+	}
+}
+
+type blockingCFetcher struct{}
+
+func (blockingCFetcher) CFetch(cancel <-chan struct{}, key fetchmgr.KStr) (int, error) {
+	<-cancel
+	return 0, errors.New("canceled")
+}
+
+func TestCNewHashedCancel(t *testing.T) {
+	cached := CNewHashed[fetchmgr.KStr, int](blockingCFetcher{})
+	defer cached.Close()
+
+	cancel := make(chan struct{})
+	close(cancel)
+	v, err := cached.CFetch(cancel, "key")
+	if err != fetchmgr.ErrFetchCanceled {
+		t.Fatalf("Gets (%v, %v), wants ErrFetchCanceled", v, err)
+	}
+}
+
+func TestTyped(t *testing.T) {
+	untyped := fetchmgr.AsCFetcher{Fetcher: fetchmgr.FuncFetcher(func(k interface{}) (interface{}, error) {
+		return k, nil
+	})}
+
+	typed := Typed[string, string](untyped)
+	v, err := typed.CFetch(nil, "key")
+	if err != nil || v != "key" {
+		t.Fatalf(`Gets (%v, %v), wants ("key", nil)`, v, err)
+	}
+
+	mistyped := Typed[string, int](untyped)
+	n, err := mistyped.CFetch(nil, "key")
+	if !errors.Is(err, ErrUnexpectedType) {
+		t.Fatalf("Gets (%v, %v), wants ErrUnexpectedType", n, err)
+	}
+
+	_, err = Untyped[string, string](typed).CFetch(nil, 1)
+	if !errors.Is(err, ErrUnexpectedType) {
+		t.Fatalf("Gets %v, wants ErrUnexpectedType", err)
+	}
+}
+
+func TestSafeFetcher(t *testing.T) {
+	var cnt int
+	sf := NewSafeFetcher[int, int](FuncFetcher[int, int](func(k int) (int, error) {
+		cnt++
+		return cnt, nil
+	}))
+
+	done := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				_, _ = sf.Fetch(j)
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	if cnt != 1000 {
+		t.Fatalf("Gets %d, wants 1000", cnt)
+	}
+}
+
+type versionCFetcher struct {
+	revalidated int32
+}
+
+func (vf *versionCFetcher) CFetch(cancel <-chan struct{}, key string) (*int, error) {
+	v := 1
+	return &v, nil
+}
+
+func (vf *versionCFetcher) Revalidate(cancel <-chan struct{}, key string, old *int) (*int, bool, error) {
+	atomic.AddInt32(&vf.revalidated, 1)
+	return nil, false, nil
+}
+
+func TestRevalidator(t *testing.T) {
+	vf := &versionCFetcher{}
+	cached := CNew[string, *int](
+		vf,
+		fetchmgr.SetTTL(time.Millisecond),
+		fetchmgr.SetInterval(time.Millisecond),
+		fetchmgr.SetStaleTTL(time.Minute),
+	)
+	defer cached.Close()
+
+	first, _ := cached.CFetch(nil, "key")
+	time.Sleep(10 * time.Millisecond)
+	second, _ := cached.CFetch(nil, "key")
+
+	if first != second {
+		t.Fatalf("Gets %v, wants the old value %v", second, first)
+	}
+	if n := atomic.LoadInt32(&vf.revalidated); n != 1 {
+		t.Fatalf("Gets %d revalidations, wants 1", n)
+	}
+}
+
+func TestTypedRevalidator(t *testing.T) {
+	vf := &versionCFetcher{}
+	limited := Typed[string, *int](fetchmgr.NewLimitedCFetcher(Untyped[string, *int](vf), 1))
+	r, ok := limited.(Revalidator[string, *int])
+	if !ok {
+		t.Fatalf("Gets %T, wants Revalidator", limited)
+	}
+	if _, changed, err := r.Revalidate(nil, "key", nil); changed || err != nil {
+		t.Fatalf("Gets (%v, %v), wants (false, nil)", changed, err)
+	}
+	if n := atomic.LoadInt32(&vf.revalidated); n != 1 {
+		t.Fatalf("Gets %d revalidations, wants 1", n)
+	}
+
+	f := AsCFetcher[string, *int]{FuncFetcher[string, *int](func(string) (*int, error) { return nil, nil })}
+	limited = Typed[string, *int](fetchmgr.NewLimitedCFetcher(Untyped[string, *int](f), 1))
+	if _, ok := limited.(Revalidator[string, *int]); ok {
+		t.Fatal("Gets Revalidator, wants a plain CFetcher")
+	}
+}
+
+func TestCachedFetcher(t *testing.T) {
+	var cnt int32
+	fetcher := FuncFetcher[userID, string](func(id userID) (string, error) {
+		atomic.AddInt32(&cnt, 1)
+		return "user", nil
+	})
+	cached := NewCachedFetcher[userID, string](fetcher, time.Minute, time.Second)
+	defer cached.Close()
+
+	for i := 0; i < 2; i++ {
+		name, err := cached.Fetch(1)
+		if err != nil || name != "user" {
+			t.Fatalf(`Gets (%q, %v), wants ("user", nil)`, name, err)
+		}
+	}
+	if n := atomic.LoadInt32(&cnt); n != 1 {
+		t.Fatalf("Gets %d calls, wants 1", n)
+	}
+}
+
+func TestBucketedCFetcher(t *testing.T) {
+	var cnts [2]int32
+	fs := make([]CFetcher[userID, int], len(cnts))
+	for i := range fs {
+		i := i
+		fs[i] = AsCFetcher[userID, int]{FuncFetcher[userID, int](func(id userID) (int, error) {
+			atomic.AddInt32(&cnts[i], 1)
+			return i, nil
+		})}
+	}
+	bucketed := NewBucketedCFetcherWithHash(fs, func(id userID) uint { return uint(id) })
+	defer bucketed.Close()
+
+	for id := userID(0); id < 4; id++ {
+		v, err := bucketed.CFetch(nil, id)
+		if err != nil {
+			t.Fatalf("Gets %v, wants nil", err)
+		}
+		if v != int(id)%2 {
+			t.Fatalf("Gets %d for %d, wants %d", v, id, int(id)%2)
+		}
+	}
+	if cnts != [2]int32{2, 2} {
+		t.Fatalf("Gets %v calls, wants [2 2]", cnts)
+	}
+}
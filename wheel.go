@@ -0,0 +1,268 @@
+package fetchmgr
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+)
+
+// timingWheel is a hierarchical timing wheel which expires keys of multiple
+// CachedCFetchers on a single timer. Each slot of the level n covers
+// wheelSize^n ticks. The timer skips ticks which have nothing to do.
+type timingWheel struct {
+	clock   Clock
+	tick    time.Duration
+	start   time.Time
+	mutex   sync.Mutex
+	now     uint64
+	count   int
+	refs    int
+	slots   [wheelLevels][wheelSize][]wheelItem
+	timer   Timer // nil while there are no items
+	timerAt uint64
+	gen     int // Identifies the current timer
+	stopped bool
+}
+
+type wheelItem struct {
+	cache *CachedCFetcher
+	item  deleteItem
+	at    uint64
+}
+
+// newTimingWheel creates timingWheel which checks expirations every tick.
+// refs is the number of CachedCFetchers sharing it. The wheel stops when all
+// of them are closed.
+func newTimingWheel(tick time.Duration, refs int, clock Clock) *timingWheel {
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+
+	return &timingWheel{
+		clock: clock,
+		tick:  tick,
+		start: clock.Now(),
+		refs:  refs,
+	}
+}
+
+// retainWheel is called when a CachedCFetcher starts sharing w. It returns
+// false if w has been already stopped.
+func retainWheel(w *timingWheel) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped {
+		return false
+	}
+	w.refs++
+	return true
+}
+
+// releaseWheel is called when one of CachedCFetchers is closed
+func releaseWheel(w *timingWheel) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.refs--
+	if w.refs == 0 {
+		w.stopped = true
+		if w.timer != nil {
+			w.timer.Stop()
+			w.timer = nil
+		}
+		w.slots = [wheelLevels][wheelSize][]wheelItem{}
+		w.count = 0
+	}
+}
+
+func currentTick(w *timingWheel, t time.Time) uint64 {
+	if t.Before(w.start) {
+		return 0
+	}
+	return uint64(t.Sub(w.start) / w.tick)
+}
+
+// addItem schedules expiration of item. It drops item after the wheel stops.
+func addItem(w *timingWheel, c *CachedCFetcher, item deleteItem) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped {
+		return
+	}
+	if w.count == 0 {
+		// Nothing to expire while sleeping, so skip ticks
+		w.now = currentTick(w, w.clock.Now())
+	}
+
+	at := currentTick(w, item.expire)
+	if item.expire.After(w.start.Add(time.Duration(at) * w.tick)) {
+		at++ // Round up not to expire it early
+	}
+	if at <= w.now {
+		at = w.now + 1 // The current slot has been already processed
+	}
+
+	next := placeItem(w, wheelItem{c, item, at})
+	w.count++
+	if w.timer == nil || next < w.timerAt {
+		scheduleTickAt(w, next)
+	}
+}
+
+// scheduleTick sets the timer for the next tick which has items. w.mutex must
+// be locked.
+func scheduleTick(w *timingWheel) {
+	if w.count == 0 {
+		return
+	}
+	scheduleTickAt(w, nextTick(w))
+}
+
+// scheduleTickAt sets the timer for the tick at, replacing the current one.
+// w.mutex must be locked.
+func scheduleTickAt(w *timingWheel, at uint64) {
+	if w.stopped {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	d := w.start.Add(time.Duration(at) * w.tick).Sub(w.clock.Now())
+	if d < 0 {
+		d = 0
+	}
+	w.gen++
+	gen := w.gen
+	w.timer = w.clock.AfterFunc(d, func() { wheelTick(w, gen) })
+	w.timerAt = at
+}
+
+// nextTick returns the earliest tick when an item expires or moves to the
+// lower level. w.mutex must be locked.
+func nextTick(w *timingWheel) uint64 {
+	next := uint64(math.MaxUint64)
+	for lvl := uint(0); lvl < wheelLevels; lvl++ {
+		shift := wheelBits * lvl
+		base := w.now >> shift
+		for i := uint64(1); i <= wheelSize; i++ {
+			if len(w.slots[lvl][(base+i)&wheelMask]) > 0 {
+				if t := (base + i) << shift; t < next {
+					next = t
+				}
+				break
+			}
+		}
+	}
+	return next
+}
+
+// placeItem puts wi to the level decided by the distance from now. Due items
+// go to the current slot of the level 0. It returns the tick when wi expires
+// or moves to the lower level.
+func placeItem(w *timingWheel, wi wheelItem) uint64 {
+	if wi.at <= w.now {
+		slot := w.now & wheelMask
+		w.slots[0][slot] = append(w.slots[0][slot], wi)
+		return w.now
+	}
+
+	delta := wi.at - w.now
+	for lvl := uint(0); lvl < wheelLevels; lvl++ {
+		if delta < 1<<(wheelBits*(lvl+1)) {
+			shift := wheelBits * lvl
+			slot := (wi.at >> shift) & wheelMask
+			w.slots[lvl][slot] = append(w.slots[lvl][slot], wi)
+			return wi.at >> shift << shift
+		}
+	}
+
+	// Too far. Put it to the last slot, and place it again later.
+	shift := wheelBits * uint(wheelLevels-1)
+	last := (w.now >> shift) + wheelMask
+	w.slots[wheelLevels-1][last&wheelMask] = append(w.slots[wheelLevels-1][last&wheelMask], wi)
+	return last << shift
+}
+
+// advanceWheel moves the wheel until t and returns expired items. It does
+// nothing if the timer gen has been replaced.
+func advanceWheel(w *timingWheel, t time.Time, gen int) []wheelItem {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped || gen != w.gen {
+		return nil
+	}
+
+	var expired []wheelItem
+	target := currentTick(w, t)
+	for w.count > 0 {
+		next := nextTick(w)
+		if next > target {
+			break
+		}
+		w.now = next
+
+		// Cascade higher levels first so that items reach the level 0
+		for lvl := uint(wheelLevels - 1); lvl > 0; lvl-- {
+			if w.now&(1<<(wheelBits*lvl)-1) != 0 {
+				continue
+			}
+			slot := (w.now >> (wheelBits * lvl)) & wheelMask
+			items := w.slots[lvl][slot]
+			w.slots[lvl][slot] = nil
+			for _, wi := range items {
+				placeItem(w, wi)
+			}
+		}
+
+		slot := w.now & wheelMask
+		expired = append(expired, w.slots[0][slot]...)
+		w.count -= len(w.slots[0][slot])
+		w.slots[0][slot] = nil
+	}
+
+	// Nothing happens until the next tick, so skip to target
+	if target > w.now {
+		w.now = target
+	}
+	w.timer = nil
+	scheduleTick(w)
+
+	return expired
+}
+
+func wheelTick(w *timingWheel, gen int) {
+	expired := advanceWheel(w, w.clock.Now(), gen)
+	fireItems(expired)
+}
+
+// fireItems expires items in batches for each CachedCFetcher
+func fireItems(expired []wheelItem) {
+	if len(expired) == 0 {
+		return
+	}
+
+	batches := make(map[*CachedCFetcher][]deleteItem)
+	for _, wi := range expired {
+		batches[wi.cache] = append(batches[wi.cache], wi.item)
+	}
+
+	for c, items := range batches {
+		select {
+		case <-c.closed:
+			continue
+		default:
+		}
+		expireItems(c, items)
+	}
+}
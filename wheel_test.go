@@ -0,0 +1,72 @@
+package fetchmgr_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/hiratara/fetchmgr"
+)
+
+func testExpiration(t *testing.T, interval, ttl, offset time.Duration) {
+	t.Helper()
+
+	clk := NewFakeClock(time.Now())
+	var cnt countCFetcher
+	cached := CNew(&cnt, SetClock(clk), SetInterval(interval), SetTTL(ttl))
+	defer cached.Close()
+
+	clk.Advance(offset)
+	cached.CFetch(nil, "key")
+	clk.Advance(ttl - interval)
+	cached.CFetch(nil, "key")
+	if n := atomic.LoadInt32((*int32)(&cnt)); n != 1 {
+		t.Fatalf("Gets %d fetches before TTL, wants 1", n)
+	}
+
+	clk.Advance(2 * interval)
+	cached.CFetch(nil, "key")
+	if n := atomic.LoadInt32((*int32)(&cnt)); n != 2 {
+		t.Fatalf("Gets %d fetches after TTL, wants 2", n)
+	}
+}
+
+func TestWheelBlockBoundary(t *testing.T) {
+	// Expire across the boundary of 2^24 ticks
+	testExpiration(t, time.Millisecond, time.Minute, (1<<24)*time.Millisecond-30*time.Second)
+}
+
+func TestWheelLongTTL(t *testing.T) {
+	testExpiration(t, time.Second, 3*24*time.Hour, 0)
+	testExpiration(t, time.Second, 3*24*time.Hour, (1<<24)*time.Second-24*time.Hour)
+}
+
+// countingClock counts timers set through it
+type countingClock struct {
+	*FakeClock
+	timers int32
+}
+
+func (cc *countingClock) AfterFunc(d time.Duration, f func()) Timer {
+	atomic.AddInt32(&cc.timers, 1)
+	return cc.FakeClock.AfterFunc(d, f)
+}
+
+func TestWheelSkipsEmptyTicks(t *testing.T) {
+	clk := &countingClock{FakeClock: NewFakeClock(time.Now())}
+	var cnt countCFetcher
+	cached := CNew(&cnt, SetClock(clk), SetInterval(time.Millisecond), SetTTL(time.Hour))
+	defer cached.Close()
+
+	cached.CFetch(nil, "key")
+	clk.Advance(time.Hour + time.Millisecond)
+	cached.CFetch(nil, "key")
+	if n := atomic.LoadInt32((*int32)(&cnt)); n != 2 {
+		t.Fatalf("Gets %d fetches, wants 2", n)
+	}
+
+	// The item cascades once per level instead of ticking every millisecond
+	if n := atomic.LoadInt32(&clk.timers); n > 10 {
+		t.Fatalf("Gets %d timers, wants at most 10", n)
+	}
+}